@@ -0,0 +1,188 @@
+package chr
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+//BackgroundMaxColors is the maximum amount of colors of a background image: 4 palettes of 4 colors
+const BackgroundMaxColors = 16
+
+//Dimension in pixels of a screen, drawn from a nametable of 32x30 tiles
+const (
+	ScreenWidth  = 256
+	ScreenHeight = 240
+)
+
+//BackgroundScreen is a screen of a background made of many screens
+type BackgroundScreen struct {
+	X         int // column of the screen
+	Y         int // row of the screen
+	Nametable *Nametable
+	NewTiles  []int // tiles of the tileset first used by this screen
+}
+
+//NewBackgroundFromPNG converts an image into a tileset without duplicated tiles, whose 1st tile is empty, and a nametable of the same dimension in tiles.
+//The palette of each pixel is its color index divided by 4 and its CHR color is the color index mapped by the colormap.
+//The pixels of color 0 may belong to any palette, but the other pixels of each 16x16 block must belong to the same palette, as the PPU allows only 1 palette per block.
+func NewBackgroundFromPNG(img image.PalettedImage, colormap ColorMap) (*Tileset, *Nametable, error) {
+	tileset := NewTileset(Tile8x8)
+	tileset.tiles = append(tileset.tiles, &Tile{})
+	if err := addBackground(tileset, img, colormap); err != nil {
+		return nil, nil, err
+	}
+
+	nametable, err := newNametableFromPNG(tileset, img, colormap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tileset, nametable, nil
+}
+
+//NewScreensFromPNG converts an image made of screens of 256x240 pixels into a tileset shared by all screens, without duplicated tiles and whose 1st tile is empty,
+//and a nametable of 32x30 tiles per screen, row by row, as in NewBackgroundFromPNG.
+//Each screen tells the tiles it added to the tileset. It fails if the screens have more than 256 different tiles.
+func NewScreensFromPNG(img image.PalettedImage, colormap ColorMap) (*Tileset, []BackgroundScreen, error) {
+	bounds := img.Bounds()
+	if bounds.Dx()%ScreenWidth != 0 || bounds.Dy()%ScreenHeight != 0 {
+		return nil, nil, fmt.Errorf("image dimension %dx%d must be a multiple of the %dx%d screens", bounds.Dx(), bounds.Dy(), ScreenWidth, ScreenHeight)
+	}
+
+	tileset := NewTileset(Tile8x8)
+	tileset.tiles = append(tileset.tiles, &Tile{})
+
+	var screens []BackgroundScreen
+	for sy := 0; sy < bounds.Dy()/ScreenHeight; sy++ {
+		for sx := 0; sx < bounds.Dx()/ScreenWidth; sx++ {
+			min := bounds.Min.Add(image.Pt(sx*ScreenWidth, sy*ScreenHeight))
+			screen := croppedImage{img, image.Rectangle{min, min.Add(image.Pt(ScreenWidth, ScreenHeight))}}
+
+			first := tileset.Size()
+			if err := addBackground(tileset, screen, colormap); err != nil {
+				return nil, nil, fmt.Errorf("screen (%d,%d): %s", sx, sy, err.Error())
+			}
+			nametable, err := newNametableFromPNG(tileset, screen, colormap)
+			if err != nil {
+				return nil, nil, fmt.Errorf("screen (%d,%d): %s", sx, sy, err.Error())
+			}
+
+			bgscreen := BackgroundScreen{X: sx, Y: sy, Nametable: nametable}
+			for i := first; i < tileset.Size(); i++ {
+				bgscreen.NewTiles = append(bgscreen.NewTiles, i)
+			}
+			screens = append(screens, bgscreen)
+		}
+	}
+
+	return tileset, screens, nil
+}
+
+//croppedImage is a rectangle of a paletted image
+type croppedImage struct {
+	image.PalettedImage
+	rect image.Rectangle
+}
+
+func (img croppedImage) Bounds() image.Rectangle {
+	return img.rect
+}
+
+//addBackground adds the tiles of an image missing from the tileset, failing if the tileset gets more than 256 tiles
+func addBackground(tileset *Tileset, img image.PalettedImage, colormap ColorMap) error {
+	bounds := img.Bounds()
+	if bounds.Dx()%8 != 0 || bounds.Dy()%8 != 0 {
+		return fmt.Errorf("image dimension %dx%d must be a multiple of 8", bounds.Dx(), bounds.Dy())
+	}
+	if palette, ok := img.ColorModel().(color.Palette); ok && len(palette) > BackgroundMaxColors {
+		return fmt.Errorf("image has %d colors but a background has at most %d, 4 per palette", len(palette), BackgroundMaxColors)
+	}
+
+	for y := 0; y < bounds.Dy(); y += 8 {
+		for x := 0; x < bounds.Dx(); x += 8 {
+			tile, _ := backgroundTile(img, colormap, x, y)
+			if tileset.indexOf(&tile) >= 0 {
+				continue
+			}
+			if tileset.Size() == TilesetMaxRows*TilesetMaxCols {
+				return fmt.Errorf("tile at (%d,%d) pixels exceeds the %d tiles of a pattern table", x, y, TilesetMaxRows*TilesetMaxCols)
+			}
+			tileset.tiles = append(tileset.tiles, &tile)
+		}
+	}
+
+	return nil
+}
+
+//newNametableFromPNG builds the nametable of an image whose tiles are all in the tileset
+func newNametableFromPNG(tileset *Tileset, img image.PalettedImage, colormap ColorMap) (*Nametable, error) {
+	bounds := img.Bounds()
+	nametable := NewNametable(bounds.Dx()/8, bounds.Dy()/8)
+	palettes := make([]int, nametable.width*nametable.height)
+
+	for ty := 0; ty < nametable.height; ty++ {
+		for tx := 0; tx < nametable.width; tx++ {
+			tile, pal := backgroundTile(img, colormap, tx*8, ty*8)
+			if pal == -2 {
+				return nil, fmt.Errorf("tile at (%d,%d) pixels has colors of more than 1 palette", tx*8, ty*8)
+			}
+			nametable.Set(tx, ty, NametableCell{Idx: byte(tileset.indexOf(&tile))})
+			palettes[ty*nametable.width+tx] = pal
+		}
+	}
+
+	// the tiles with only the color 0 take the palette of their block
+	for by := 0; by < nametable.height; by += 2 {
+		for bx := 0; bx < nametable.width; bx += 2 {
+			pal := -1
+			for y := by; y < by+2 && y < nametable.height; y++ {
+				for x := bx; x < bx+2 && x < nametable.width; x++ {
+					tilePal := palettes[y*nametable.width+x]
+					if tilePal >= 0 && pal >= 0 && tilePal != pal {
+						return nil, fmt.Errorf("16x16 block at (%d,%d) pixels has colors of the palettes %d and %d", bx*8, by*8, pal, tilePal)
+					}
+					if tilePal >= 0 {
+						pal = tilePal
+					}
+				}
+			}
+			if pal < 0 {
+				pal = 0
+			}
+
+			for y := by; y < by+2 && y < nametable.height; y++ {
+				for x := bx; x < bx+2 && x < nametable.width; x++ {
+					cell := nametable.At(x, y)
+					cell.Pal = byte(pal)
+					nametable.Set(x, y, cell)
+				}
+			}
+		}
+	}
+
+	return nametable, nil
+}
+
+//backgroundTile returns the tile of an image at (x,y) pixels with its palette: -1 if it has only the color 0 and -2 if it has more than 1 palette
+func backgroundTile(img image.PalettedImage, colormap ColorMap, x, y int) (Tile, int) {
+	bounds := img.Bounds()
+	pal := -1
+	var pixels [8][8]byte
+	for py := 0; py < 8; py++ {
+		for px := 0; px < 8; px++ {
+			idx := img.ColorIndexAt(bounds.Min.X+x+px, bounds.Min.Y+y+py)
+			pixels[py][px] = colormap.Map(idx)
+			if pixels[py][px] == 0 {
+				continue
+			}
+			if pal >= 0 && pal != int(idx/4) {
+				pal = -2
+			} else if pal == -1 {
+				pal = int(idx / 4)
+			}
+		}
+	}
+
+	return NewTileFromPixels(pixels), pal
+}
@@ -0,0 +1,201 @@
+package chr
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//BoxesSuffix is added to the file name of a metasprite to name the file of its boxes
+const BoxesSuffix = "_boxes"
+
+//Box is a rectangle relative to the metasprite origin, including the pixels at both corners
+type Box struct {
+	X1 int8
+	Y1 int8
+	X2 int8
+	Y2 int8
+}
+
+//Boxes are the bounding box and the hitboxes of a metasprite
+type Boxes struct {
+	Bounds   Box
+	Hitboxes []Box
+}
+
+//NewBox returns the box around the pixels of an image whose color index matches, relative to the metasprite origin.
+//As in NewMetaspriteFromTileset, the origin is the bottom left corner of the image moved by (dx,dy).
+//The box is empty, with X2 < X1, when no pixel matches.
+func NewBox(img image.PalettedImage, match func(idx byte) bool, dx, dy int8) (Box, error) {
+	bounds := img.Bounds()
+	found := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if match(img.ColorIndexAt(x, y)) {
+				found = found.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	if found.Empty() {
+		return Box{0, 0, -1, -1}, nil
+	}
+
+	return newBoxInRange(
+		found.Min.X-bounds.Min.X+int(dx),
+		found.Min.Y-bounds.Max.Y+int(dy),
+		found.Max.X-1-bounds.Min.X+int(dx),
+		found.Max.Y-1-bounds.Max.Y+int(dy),
+	)
+}
+
+//Empty returns true if the box contains no pixels
+func (box Box) Empty() bool {
+	return box.X2 < box.X1 || box.Y2 < box.Y1
+}
+
+//Mirror returns the box horizontally mirrored around the vertical axis at x = pivot, as Metasprite.Mirror
+func (box Box) Mirror(pivot int) (Box, error) {
+	if box.Empty() {
+		return box, nil
+	}
+	return newBoxInRange(2*pivot-1-int(box.X2), int(box.Y1), 2*pivot-1-int(box.X1), int(box.Y2))
+}
+
+//Flip returns the box vertically mirrored around the horizontal axis at y = pivot, as Metasprite.Flip
+func (box Box) Flip(pivot int) (Box, error) {
+	if box.Empty() {
+		return box, nil
+	}
+	return newBoxInRange(int(box.X1), 2*pivot-1-int(box.Y2), int(box.X2), 2*pivot-1-int(box.Y1))
+}
+
+//Bytes transform a Box into an array of bytes in the format [x1, y1, x2, y2]
+func (box Box) Bytes() []byte {
+	return []byte{byte(box.X1), byte(box.Y1), byte(box.X2), byte(box.Y2)}
+}
+
+func (box Box) String() string {
+	return fmt.Sprintf("%d, %d, %d, %d", box.X1, box.Y1, box.X2, box.Y2)
+}
+
+func newBoxInRange(x1, y1, x2, y2 int) (Box, error) {
+	for _, v := range []int{x1, y1, x2, y2} {
+		if v < math.MinInt8 || v > math.MaxInt8 {
+			return Box{}, fmt.Errorf("box (%d,%d)-(%d,%d) is out of range [%d,%d]", x1, y1, x2, y2, math.MinInt8, math.MaxInt8)
+		}
+	}
+	return Box{int8(x1), int8(y1), int8(x2), int8(y2)}, nil
+}
+
+//Mirror returns the boxes horizontally mirrored around the vertical axis at x = pivot
+func (boxes *Boxes) Mirror(pivot int) (*Boxes, error) {
+	return boxes.transform(func(box Box) (Box, error) { return box.Mirror(pivot) })
+}
+
+//Flip returns the boxes vertically mirrored around the horizontal axis at y = pivot
+func (boxes *Boxes) Flip(pivot int) (*Boxes, error) {
+	return boxes.transform(func(box Box) (Box, error) { return box.Flip(pivot) })
+}
+
+func (boxes *Boxes) transform(fn func(Box) (Box, error)) (*Boxes, error) {
+	bounds, err := fn(boxes.Bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed := &Boxes{Bounds: bounds}
+	for _, hitbox := range boxes.Hitboxes {
+		hitbox, err := fn(hitbox)
+		if err != nil {
+			return nil, err
+		}
+		transformed.Hitboxes = append(transformed.Hitboxes, hitbox)
+	}
+
+	return transformed, nil
+}
+
+//WriteC write the boxes of the metasprite named filename to a .c and .h files suffixed by BoxesSuffix
+func (boxes *Boxes) WriteC(filename string) error {
+	cfilename := ChangeFileExtension(boxesFileName(filename), "c")
+	cfile, err := os.OpenFile(cfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer cfile.Close()
+
+	hfilename := ChangeFileExtension(boxesFileName(filename), "h")
+	hfile, err := os.OpenFile(hfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer hfile.Close()
+
+	varname := labelName(filename)
+	fmt.Fprintf(hfile, "extern char %s_bbox[4];\n", varname)
+	fmt.Fprintf(hfile, "extern char %s_hitbox[%d];\n", varname, len(boxes.Hitboxes)*4+1)
+	fmt.Fprintf(cfile, "const char %s_bbox[] = {\n", varname)
+	fmt.Fprintf(cfile, "\t%s,\n", boxes.Bounds.String())
+	fmt.Fprintln(cfile, "};")
+	fmt.Fprintf(cfile, "const char %s_hitbox[] = {\n", varname)
+	for _, hitbox := range boxes.Hitboxes {
+		fmt.Fprintf(cfile, "\t%s,\n", hitbox.String())
+	}
+	fmt.Fprintln(cfile, "\t0x80,")
+	fmt.Fprintln(cfile, "};")
+
+	return nil
+}
+
+//WriteAsm write the boxes of the metasprite named filename to a .inc file suffixed by BoxesSuffix
+func (boxes *Boxes) WriteAsm(filename string) error {
+	asmfilename := ChangeFileExtension(boxesFileName(filename), "inc")
+	asmfile, err := os.OpenFile(asmfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer asmfile.Close()
+
+	varname := labelName(filename)
+	fmt.Fprintf(asmfile, "%s_bbox:\n", varname)
+	fmt.Fprintf(asmfile, "\t.byte %s\n", boxes.Bounds.String())
+	fmt.Fprintf(asmfile, "%s_hitbox:\n", varname)
+	for _, hitbox := range boxes.Hitboxes {
+		fmt.Fprintf(asmfile, "\t.byte %s\n", hitbox.String())
+	}
+	fmt.Fprintln(asmfile, "\t.byte $80")
+
+	return nil
+}
+
+//WriteBin write the boxes of the metasprite named filename to a .bin file suffixed by BoxesSuffix.
+//The bounding box comes first, followed by the hitboxes and the 0x80 terminator.
+func (boxes *Boxes) WriteBin(filename string) error {
+	binfilename := ChangeFileExtension(boxesFileName(filename), "bin")
+	binfile, err := os.OpenFile(binfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer binfile.Close()
+
+	if _, err := binfile.Write(boxes.Bounds.Bytes()); err != nil {
+		return err
+	}
+	for _, hitbox := range boxes.Hitboxes {
+		if _, err := binfile.Write(hitbox.Bytes()); err != nil {
+			return err
+		}
+	}
+	_, err = binfile.Write([]byte{0x80})
+
+	return err
+}
+
+func boxesFileName(filename string) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + BoxesSuffix + ext
+}
@@ -1,10 +1,15 @@
 package chr
 
-import "strings"
+import (
+	"path/filepath"
+	"strings"
+)
 
 const (
-	spriteMirrorOpt = (1 << 6)
-	spriteFlipOpt   = (1 << 7)
+	spritePaletteOpt  = 3
+	spritePriorityOpt = (1 << 5)
+	spriteMirrorOpt   = (1 << 6)
+	spriteFlipOpt     = (1 << 7)
 )
 
 //CleanupTiles removes empty and duplicated tiles
@@ -222,9 +227,13 @@ func removeDuplicated8x16Tiles(tileset *Tileset, metasprite *Metasprite, delMirr
 	}
 }
 
-func changeFileExtension(name, extension string) string {
-	if dot := strings.LastIndex(name, "."); dot > -1 {
-		return name[:dot] + "." + extension
-	}
-	return name + "." + extension
+//ChangeFileExtension replaces the extension of a file name, or adds it when the name has none
+func ChangeFileExtension(name, extension string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + extension
+}
+
+func labelName(filename string) string {
+	label := filepath.Base(filename)
+	label = strings.TrimSuffix(label, filepath.Ext(label))
+	return strings.Replace(label, "-", "_", -1)
 }
@@ -0,0 +1,63 @@
+package chr
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+//ColorMap maps the color indexes of a source image to CHR color values [0,3]
+type ColorMap []byte
+
+//NewColorMap builds a color map that swaps the background color index with the index 0
+func NewColorMap(bgColorIdx byte) ColorMap {
+	colormap := ColorMap{0, 1, 2, 3}
+	if bgColorIdx > 0 && int(bgColorIdx) < len(colormap) {
+		colormap[0], colormap[bgColorIdx] = bgColorIdx, 0
+	}
+
+	return colormap
+}
+
+//ParseColorMap builds a color map from a comma separated list of CHR color values, one for each source color index.
+//E.g.: "0,2,1,3,1" swaps the source indexes 1 and 2 and maps the source index 4 to 1
+func ParseColorMap(str string) (ColorMap, error) {
+	var colormap ColorMap
+	for i, field := range strings.Split(str, ",") {
+		value, err := strconv.ParseUint(strings.TrimSpace(field), 10, 8)
+		if err != nil || value > 3 {
+			return nil, fmt.Errorf("source color index %d must be mapped to a value in [0,3]: '%s'", i, field)
+		}
+		colormap = append(colormap, byte(value))
+	}
+
+	return colormap, nil
+}
+
+//Map returns the CHR color value of a source color index
+func (colormap ColorMap) Map(idx byte) byte {
+	if int(idx) < len(colormap) {
+		return colormap[idx]
+	}
+	return idx
+}
+
+//Validate checks if all color indexes used by the image are mapped and if the map fits the image palette
+func (colormap ColorMap) Validate(img image.PalettedImage) error {
+	if palette, ok := img.ColorModel().(color.Palette); ok && len(colormap) > len(palette) {
+		return fmt.Errorf("color map has %d entries but the image palette has only %d colors", len(colormap), len(palette))
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if idx := img.ColorIndexAt(x, y); int(idx) >= len(colormap) {
+				return fmt.Errorf("color index %d at (%d,%d) is not mapped", idx, x, y)
+			}
+		}
+	}
+
+	return nil
+}
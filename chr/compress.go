@@ -0,0 +1,161 @@
+package chr
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//Compressions of the data written to files
+const (
+	CompressNone = "none"
+	CompressRLE  = "rle"
+	CompressLZ4  = "lz4"
+)
+
+//Limits of the LZ4 block format
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5      // the last bytes are always literals
+	lz4MatchLimit   = 12     // the last match starts at least 12 bytes before the end
+	lz4MaxOffset    = 0xffff // matches are at most 64KB behind
+	lz4MaxChain     = 256    // candidates checked for each match
+)
+
+//Compress compresses the bytes with one of the compressions
+func Compress(bytes []byte, compression string) ([]byte, error) {
+	switch compression {
+	case CompressNone, "":
+		return bytes, nil
+	case CompressRLE:
+		return CompressRLEBytes(bytes)
+	case CompressLZ4:
+		return CompressLZ4Bytes(bytes), nil
+	}
+	return nil, fmt.Errorf("unknown compression '%s'", compression)
+}
+
+//CompressRLEBytes compresses the bytes with the RLE format decompressed by vram_unrle of neslib.
+//The 1st byte is a tag, the lowest byte value not found in the data.
+//Each following byte other than the tag is copied, while the tag followed by a count repeats the last copied byte count times.
+//The tag followed by 0 ends the data. It fails when the data have all the 256 byte values, leaving no tag.
+//
+//Test vector: 01 01 01 01 02 03 03 compresses into 00 01 00 03 02 03 03 00 00
+func CompressRLEBytes(bytes []byte) ([]byte, error) {
+	var used [256]bool
+	for _, b := range bytes {
+		used[b] = true
+	}
+	tag := -1
+	for b := range used {
+		if !used[b] {
+			tag = b
+			break
+		}
+	}
+	if tag < 0 {
+		return nil, fmt.Errorf("cannot compress with RLE data having all the 256 byte values")
+	}
+
+	compressed := []byte{byte(tag)}
+	for i := 0; i < len(bytes); {
+		run := 1
+		for i+run < len(bytes) && bytes[i+run] == bytes[i] && run < 256 {
+			run++
+		}
+
+		compressed = append(compressed, bytes[i])
+		switch repeat := run - 1; {
+		case repeat == 1:
+			compressed = append(compressed, bytes[i])
+		case repeat > 1:
+			compressed = append(compressed, byte(tag), byte(repeat))
+		}
+		i += run
+	}
+
+	return append(compressed, byte(tag), 0), nil
+}
+
+//CompressLZ4Bytes compresses the bytes into a raw LZ4 block, without frame, as decompressed by LZ4_decompress_safe and the 6502 LZ4 decompressors.
+//Each sequence has a token, with the amount of literals in the high nibble and the match length minus 4 in the low nibble,
+//followed by the extra bytes of the amount of literals, the literals, the offset of the match in little endian and the extra bytes of the match length.
+//The last sequence has only literals.
+//
+//Test vector: the 16 bytes of "abcd" 4 times, 61 62 63 64 61 62 63 64 61 62 63 64 61 62 63 64, compress into
+//43 61 62 63 64 04 00 50 64 61 62 63 64: 4 literals and a match of 7 bytes at offset 4, then the last 5 literals.
+func CompressLZ4Bytes(bytes []byte) []byte {
+	var compressed []byte
+	chains := make(map[uint32][]int)
+	anchor := 0
+
+	for i := 0; i+lz4MatchLimit <= len(bytes); {
+		key := binary.LittleEndian.Uint32(bytes[i:])
+		offset, length := 0, 0
+		candidates := chains[key]
+		for c := len(candidates) - 1; c >= 0 && c >= len(candidates)-lz4MaxChain; c-- {
+			pos := candidates[c]
+			if i-pos > lz4MaxOffset {
+				break
+			}
+			n := 0
+			for i+n < len(bytes)-lz4LastLiterals && bytes[pos+n] == bytes[i+n] {
+				n++
+			}
+			if n > length {
+				offset, length = i-pos, n
+			}
+		}
+		chains[key] = append(chains[key], i)
+
+		if length < lz4MinMatch {
+			i++
+			continue
+		}
+
+		compressed = appendLZ4Sequence(compressed, bytes[anchor:i], offset, length)
+		for j := i + 1; j < i+length && j+4 <= len(bytes); j++ {
+			k := binary.LittleEndian.Uint32(bytes[j:])
+			chains[k] = append(chains[k], j)
+		}
+		i += length
+		anchor = i
+	}
+
+	return appendLZ4Sequence(compressed, bytes[anchor:], 0, 0)
+}
+
+//appendLZ4Sequence appends a sequence of literals followed by a match, or only literals when the match length is 0
+func appendLZ4Sequence(compressed, literals []byte, offset, length int) []byte {
+	token := byte(minInt(len(literals), 15)) << 4
+	if length > 0 {
+		token |= byte(minInt(length-lz4MinMatch, 15))
+	}
+
+	compressed = append(compressed, token)
+	compressed = appendLZ4Length(compressed, len(literals))
+	compressed = append(compressed, literals...)
+	if length > 0 {
+		compressed = append(compressed, byte(offset), byte(offset>>8))
+		compressed = appendLZ4Length(compressed, length-lz4MinMatch)
+	}
+
+	return compressed
+}
+
+//appendLZ4Length appends the extra bytes of a length that does not fit in a nibble
+func appendLZ4Length(compressed []byte, length int) []byte {
+	if length < 15 {
+		return compressed
+	}
+	for length -= 15; length >= 255; length -= 255 {
+		compressed = append(compressed, 255)
+	}
+	return append(compressed, byte(length))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
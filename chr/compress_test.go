@@ -0,0 +1,128 @@
+package chr
+
+import (
+	"bytes"
+	"testing"
+)
+
+//unrle decompresses the RLE format as vram_unrle of neslib does
+func unrle(compressed []byte) []byte {
+	var data []byte
+	tag := compressed[0]
+	for i := 1; ; {
+		b := compressed[i]
+		i++
+		if b != tag {
+			data = append(data, b)
+			continue
+		}
+		count := compressed[i]
+		i++
+		if count == 0 {
+			return data
+		}
+		for ; count > 0; count-- {
+			data = append(data, data[len(data)-1])
+		}
+	}
+}
+
+//unlz4 decompresses a raw LZ4 block as LZ4_decompress_safe does
+func unlz4(compressed []byte) []byte {
+	var data []byte
+	readLength := func(i, length int) (int, int) {
+		if length < 15 {
+			return i, length
+		}
+		for {
+			b := int(compressed[i])
+			i++
+			length += b
+			if b != 255 {
+				return i, length
+			}
+		}
+	}
+
+	for i := 0; i < len(compressed); {
+		token := compressed[i]
+		i++
+		var literals int
+		i, literals = readLength(i, int(token>>4))
+		data = append(data, compressed[i:i+literals]...)
+		i += literals
+		if i == len(compressed) {
+			break
+		}
+
+		offset := int(compressed[i]) | int(compressed[i+1])<<8
+		i += 2
+		var length int
+		i, length = readLength(i, int(token&0x0f))
+		for length += lz4MinMatch; length > 0; length-- {
+			data = append(data, data[len(data)-offset])
+		}
+	}
+	return data
+}
+
+func TestCompressRLEBytes(t *testing.T) {
+	long := bytes.Repeat([]byte{0x05}, 300)
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"documented vector", []byte{0x01, 0x01, 0x01, 0x01, 0x02, 0x03, 0x03}, []byte{0x00, 0x01, 0x00, 0x03, 0x02, 0x03, 0x03, 0x00, 0x00}},
+		{"empty", nil, []byte{0x00, 0x00, 0x00}},
+		{"run longer than 256", long, []byte{0x00, 0x05, 0x00, 0xff, 0x05, 0x00, 0x2b, 0x00, 0x00}},
+	}
+
+	for _, test := range tests {
+		compressed, err := CompressRLEBytes(test.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+		if !bytes.Equal(compressed, test.want) {
+			t.Errorf("%s: compressed into % x, want % x", test.name, compressed, test.want)
+		}
+		if data := unrle(compressed); !bytes.Equal(data, test.data) {
+			t.Errorf("%s: decompressed into % x, want % x", test.name, data, test.data)
+		}
+	}
+}
+
+func TestCompressRLEBytesAllValues(t *testing.T) {
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if _, err := CompressRLEBytes(data); err == nil {
+		t.Error("expected an error compressing all the 256 byte values")
+	}
+}
+
+func TestCompressLZ4Bytes(t *testing.T) {
+	long := bytes.Repeat([]byte{0x05}, 300)
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"documented vector", []byte("abcdabcdabcdabcd"), []byte{0x43, 0x61, 0x62, 0x63, 0x64, 0x04, 0x00, 0x50, 0x64, 0x61, 0x62, 0x63, 0x64}},
+		{"empty", nil, []byte{0x00}},
+		{"shorter than 13 bytes", []byte("aaaaaaaaaaaa"), append([]byte{0xc0}, "aaaaaaaaaaaa"...)},
+		{"run longer than 256", long, nil},
+	}
+
+	for _, test := range tests {
+		compressed := CompressLZ4Bytes(test.data)
+		if test.want != nil && !bytes.Equal(compressed, test.want) {
+			t.Errorf("%s: compressed into % x, want % x", test.name, compressed, test.want)
+		}
+		if data := unlz4(compressed); !bytes.Equal(data, test.data) {
+			t.Errorf("%s: decompressed into % x, want % x", test.name, data, test.data)
+		}
+	}
+}
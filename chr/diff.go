@@ -0,0 +1,161 @@
+package chr
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+//TileChange is the kind of change of a tile from a tileset to another
+type TileChange string
+
+//Tile changes
+const (
+	TileAdded         TileChange = "added"
+	TileRemoved       TileChange = "removed"
+	TileChanged       TileChange = "changed"
+	TileMoved         TileChange = "moved"
+	TileMirrored      TileChange = "mirrored"
+	TileFlipped       TileChange = "flipped"
+	TileMirrorFlipped TileChange = "mirror-flipped"
+)
+
+//TileDiff describes how the tile at position Idx changed
+type TileDiff struct {
+	Idx    int
+	From   int // position of the tile it was moved or mirrored from, -1 if none
+	Change TileChange
+}
+
+//diffPalette has the TilePalette colors followed by the same colors tinted red for the different pixels
+var diffPalette = append(append(color.Palette{}, TilePalette...),
+	color.RGBA{0x60, 0x00, 0x00, 0xff},
+	color.RGBA{0xa0, 0x20, 0x20, 0xff},
+	color.RGBA{0xe0, 0x50, 0x50, 0xff},
+	color.RGBA{0xff, 0x90, 0x90, 0xff},
+)
+
+//Diff compares 2 tilesets tile by tile, returning the changes from the 1st one to the 2nd one sorted by position.
+//The empty tiles are never taken as moved, and the tiles of the 1st tileset not found in the 2nd one are removed, so a tile deleted from the middle is removed and the following tiles are moved.
+func Diff(before, after *Tileset) []TileDiff {
+	var diffs []TileDiff
+	kept := make([]bool, before.Size())
+	var unmatched []int
+
+	for i := 0; i < after.Size(); i++ {
+		tile := after.At(i)
+		if i < before.Size() && tile.Equals(before.At(i)) {
+			kept[i] = true
+			continue
+		}
+
+		if diff, found := findOrigin(before, tile, i); found {
+			kept[diff.From] = true
+			diffs = append(diffs, diff)
+		} else {
+			unmatched = append(unmatched, i)
+		}
+	}
+
+	// a tile changed in place only if the tile that was there is not found elsewhere, otherwise an empty tile just fills the place left
+	for _, i := range unmatched {
+		switch {
+		case i < before.Size() && !kept[i]:
+			kept[i] = true
+			diffs = append(diffs, TileDiff{Idx: i, From: -1, Change: TileChanged})
+		case i >= before.Size() || !after.At(i).Empty():
+			diffs = append(diffs, TileDiff{Idx: i, From: -1, Change: TileAdded})
+		}
+	}
+
+	for j, found := range kept {
+		if !found && (j >= after.Size() || !before.At(j).Empty()) {
+			diffs = append(diffs, TileDiff{Idx: j, From: -1, Change: TileRemoved})
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Idx < diffs[j].Idx })
+	return diffs
+}
+
+//findOrigin looks for a tile that was moved, mirrored or flipped into the position i, preferring the tile that was at the same position.
+//An empty tile has no origin, as any empty tile would match it.
+func findOrigin(before *Tileset, tile *Tile, i int) (TileDiff, bool) {
+	if tile.Empty() {
+		return TileDiff{}, false
+	}
+
+	if i < before.Size() {
+		if change, ok := variantOf(tile, before.At(i)); ok {
+			return TileDiff{Idx: i, From: i, Change: change}, true
+		}
+	}
+
+	for j := 0; j < before.Size(); j++ {
+		if j != i && tile.Equals(before.At(j)) {
+			return TileDiff{Idx: i, From: j, Change: TileMoved}, true
+		}
+	}
+
+	for j := 0; j < before.Size(); j++ {
+		if change, ok := variantOf(tile, before.At(j)); ok && j != i {
+			return TileDiff{Idx: i, From: j, Change: change}, true
+		}
+	}
+
+	return TileDiff{}, false
+}
+
+func variantOf(tile, other *Tile) (TileChange, bool) {
+	switch {
+	case tile.Mirrored(other):
+		return TileMirrored, true
+	case tile.Flipped(other):
+		return TileFlipped, true
+	case tile.MirrorFlipped(other):
+		return TileMirrorFlipped, true
+	}
+	return "", false
+}
+
+//DiffImage draws 2 tilesets side by side, highlighting in red the pixels that differ
+func DiffImage(before, after *Tileset) *image.Paletted {
+	size := before.Size()
+	if after.Size() > size {
+		size = after.Size()
+	}
+	rows := (size + TilesetMaxCols - 1) / TilesetMaxCols
+	width := TilesetMaxCols * 8
+
+	img := image.NewPaletted(image.Rect(0, 0, width*2+8, rows*8), diffPalette)
+	for i := 0; i < size; i++ {
+		x, y := (i%TilesetMaxCols)*8, (i/TilesetMaxCols)*8
+		drawDiffTile(img, x, y, tileAt(before, i), tileAt(after, i))
+		drawDiffTile(img, width+8+x, y, tileAt(after, i), tileAt(before, i))
+	}
+
+	return img
+}
+
+func drawDiffTile(img *image.Paletted, x, y int, tile, other *Tile) {
+	if tile == nil {
+		return
+	}
+
+	for py := 0; py < 8; py++ {
+		for px := 0; px < 8; px++ {
+			idx := tile.Pixel(px, py)
+			if other == nil || other.Pixel(px, py) != idx {
+				idx += 4
+			}
+			img.SetColorIndex(x+px, y+py, idx)
+		}
+	}
+}
+
+func tileAt(tileset *Tileset, i int) *Tile {
+	if i < tileset.Size() {
+		return tileset.At(i)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package chr
+
+import (
+	"fmt"
+	"image"
+)
+
+//NewCanvas returns an image using the NESPalette filled with the universal background color, where metasprites can be drawn
+func NewCanvas(rect image.Rectangle, palettes Palettes) *image.Paletted {
+	img := image.NewPaletted(rect, NESPalette)
+	for i := range img.Pix {
+		img.Pix[i] = palettes[0][0]
+	}
+	return img
+}
+
+//Draw draws the metasprite over an image using the NESPalette, with the metasprite origin at the given point of the image.
+//As in the PPU the color 0 is transparent and the 1st sprite is drawn over the others.
+//There is no background, so the sprites behind it are drawn as the others.
+func (metasprite *Metasprite) Draw(img *image.Paletted, origin image.Point, tileset *Tileset, tiledim TileDimension, palettes Palettes) error {
+	for i := metasprite.Size() - 1; i >= 0; i-- {
+		spr := metasprite.At(i)
+
+		tiles := []int{int(spr.Idx)}
+		if tiledim == Tile8x16 {
+			tiles = append(tiles, int(spr.Idx)+1)
+			// the PPU swaps the top and bottom tiles of a flipped 8x16 sprite
+			if spr.Flipped() {
+				tiles[0], tiles[1] = tiles[1], tiles[0]
+			}
+		}
+
+		for half, idx := range tiles {
+			if idx >= tileset.Size() {
+				return fmt.Errorf("sprite %d uses the tile 0x%02x but there are only %d tiles", i, idx, tileset.Size())
+			}
+			tile := tileset.At(idx)
+			if spr.Mirrored() && spr.Flipped() {
+				tile = tile.MirrorFlip()
+			} else if spr.Mirrored() {
+				tile = tile.Mirror()
+			} else if spr.Flipped() {
+				tile = tile.Flip()
+			}
+
+			x, y := origin.X+int(spr.X), origin.Y+int(spr.Y)+half*8
+			for py := 0; py < 8; py++ {
+				for px := 0; px < 8; px++ {
+					color := tile.Pixel(px, py)
+					if color != 0 && image.Pt(x+px, y+py).In(img.Rect) {
+						img.SetColorIndex(x+px, y+py, palettes.Color(spr.Palette(), color))
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
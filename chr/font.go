@@ -0,0 +1,203 @@
+package chr
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Font is a bitmap font read from a BDF file
+type Font struct {
+	Ascent  int
+	Descent int
+	glyphs  map[rune]*Glyph
+}
+
+//Glyph is the bitmap of a character of a font, with rows from top to bottom, placed at (XOff,YOff) from the origin at the baseline
+type Glyph struct {
+	Width  int
+	Height int
+	XOff   int
+	YOff   int
+	rows   [][]byte
+}
+
+//ReadBDF reads a font from a BDF (Glyph Bitmap Distribution Format) file
+func ReadBDF(r io.Reader) (*Font, error) {
+	font := &Font{glyphs: make(map[rune]*Glyph)}
+	var glyph *Glyph
+	var encoding int
+	var bitmap bool
+	ascent, descent := -1, -1
+	var bboxH, bboxY int
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if bitmap && fields[0] != "ENDCHAR" {
+			row, err := hex.DecodeString(fields[0])
+			if err != nil || len(row) < (glyph.Width+7)/8 {
+				return nil, fmt.Errorf("line %d: invalid bitmap row '%s'", n, fields[0])
+			}
+			glyph.rows = append(glyph.rows, row)
+			continue
+		}
+
+		values, err := atois(fields[1:])
+		switch fields[0] {
+		case "FONTBOUNDINGBOX":
+			if err != nil || len(values) != 4 {
+				return nil, fmt.Errorf("line %d: expected FONTBOUNDINGBOX W H X Y", n)
+			}
+			bboxH, bboxY = values[1], values[3]
+		case "FONT_ASCENT", "FONT_DESCENT":
+			if err != nil || len(values) != 1 {
+				return nil, fmt.Errorf("line %d: expected %s N", n, fields[0])
+			}
+			if fields[0] == "FONT_ASCENT" {
+				ascent = values[0]
+			} else {
+				descent = values[0]
+			}
+		case "STARTCHAR":
+			glyph, encoding = new(Glyph), -1
+		case "ENCODING":
+			if glyph == nil || err != nil || len(values) < 1 {
+				return nil, fmt.Errorf("line %d: expected ENCODING N inside STARTCHAR", n)
+			}
+			encoding = values[0]
+		case "BBX":
+			if glyph == nil || err != nil || len(values) != 4 {
+				return nil, fmt.Errorf("line %d: expected BBX W H X Y inside STARTCHAR", n)
+			}
+			glyph.Width, glyph.Height, glyph.XOff, glyph.YOff = values[0], values[1], values[2], values[3]
+		case "BITMAP":
+			if glyph == nil {
+				return nil, fmt.Errorf("line %d: BITMAP outside STARTCHAR", n)
+			}
+			bitmap = true
+		case "ENDCHAR":
+			if glyph == nil {
+				return nil, fmt.Errorf("line %d: ENDCHAR without STARTCHAR", n)
+			}
+			if len(glyph.rows) != glyph.Height {
+				return nil, fmt.Errorf("line %d: glyph %d has %d bitmap rows, not %d", n, encoding, len(glyph.rows), glyph.Height)
+			}
+			// glyphs with the encoding -1 have no standard code, so they cannot be mapped
+			if encoding >= 0 {
+				font.glyphs[rune(encoding)] = glyph
+			}
+			glyph, bitmap = nil, false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	font.Ascent, font.Descent = ascent, descent
+	if ascent < 0 {
+		font.Ascent = bboxH + bboxY
+	}
+	if descent < 0 {
+		font.Descent = -bboxY
+	}
+
+	return font, nil
+}
+
+func atois(fields []string) ([]int, error) {
+	var values []int
+	for _, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+//Glyph returns the glyph of a character, if the font has it
+func (font *Font) Glyph(char rune) (*Glyph, bool) {
+	glyph, ok := font.glyphs[char]
+	return glyph, ok
+}
+
+//Pixel returns true if the pixel (x,y) of the glyph, from its top left, is set
+func (glyph *Glyph) Pixel(x, y int) bool {
+	return glyph.rows[y][x/8]&(0x80>>uint(x%8)) != 0
+}
+
+//NewTilesetFromFont builds a tileset with the glyphs of the characters at the given positions, drawn with the colors fg and bg.
+//Each glyph takes a tile of 8x8 pixels or, for 8x16, 2 tiles in the order of the 8x16 sprites, so the position p is at the tiles 2p and 2p+1.
+//The glyphs are placed on the baseline of the font, that is Descent pixels above the bottom of the tile, and must fit in the tile.
+//The returned table maps each character to its 1st tile. It fails when 2 characters take the same position.
+func NewTilesetFromFont(font *Font, positions map[rune]int, tiledim TileDimension, fg, bg byte) (*Tileset, *CharTable, error) {
+	tileset := NewTileset(tiledim)
+	table := NewCharTable()
+	height := tiledim.Height()
+	perGlyph := height / 8
+	baseline := height - font.Descent
+
+	// in the order of the characters, so the tileset and the errors are always the same
+	chars := make([]rune, 0, len(positions))
+	for char := range positions {
+		chars = append(chars, char)
+	}
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+
+	taken := make(map[int]rune)
+	for _, char := range chars {
+		pos := positions[char]
+		if pos < 0 || (pos+1)*perGlyph > 256 {
+			return nil, nil, fmt.Errorf("position %d of the character %q is out of range [0,%d]", pos, char, 256/perGlyph-1)
+		}
+		if other, ok := taken[pos]; ok {
+			return nil, nil, fmt.Errorf("characters %q and %q take the same position %d", other, char, pos)
+		}
+		taken[pos] = char
+		glyph, ok := font.Glyph(char)
+		if !ok {
+			return nil, nil, fmt.Errorf("font has no glyph for the character %q", char)
+		}
+		for len(tileset.tiles) < (pos+1)*perGlyph {
+			tileset.tiles = append(tileset.tiles, filledTile(bg))
+		}
+
+		top := baseline - glyph.YOff - glyph.Height
+		for y := 0; y < glyph.Height; y++ {
+			for x := 0; x < glyph.Width; x++ {
+				if !glyph.Pixel(x, y) {
+					continue
+				}
+				tx, ty := glyph.XOff+x, top+y
+				if tx < 0 || tx > 7 || ty < 0 || ty >= height {
+					return nil, nil, fmt.Errorf("glyph of the character %q does not fit in a tile of %s pixels", char, tiledim)
+				}
+				tileset.tiles[pos*perGlyph+ty/8].SetPixel(tx, ty%8, fg)
+			}
+		}
+		table.Add(string(char), []byte{byte(pos * perGlyph)})
+	}
+
+	return tileset, table, nil
+}
+
+func filledTile(color byte) *Tile {
+	var pixels [8][8]byte
+	for y := range pixels {
+		for x := range pixels[y] {
+			pixels[y][x] = color
+		}
+	}
+	tile := NewTileFromPixels(pixels)
+	return &tile
+}
@@ -2,8 +2,18 @@ package chr
 
 import (
 	"fmt"
+	"image"
+	"io"
+	"math"
 	"os"
-	"strings"
+	"sort"
+)
+
+//Metasprite sort orders, from the 1st sprite to the last, that is from the top to the bottom of the screen when drawn
+const (
+	SortByY     = "y"
+	SortByX     = "x"
+	SortByFront = "front"
 )
 
 //Metasprite is a table of sprites
@@ -38,10 +48,22 @@ func NewMetaspriteFromFile(binfile *os.File) (*Metasprite, error) {
 	}
 
 	bytes := make([]byte, stat.Size())
-	if _, err := binfile.Read(bytes); err != nil {
+	if _, err := io.ReadFull(binfile, bytes); err != nil {
 		return nil, err
 	}
 
+	return NewMetaspriteFromBytes(bytes)
+}
+
+//NewMetaspriteFromBytes builds a metasprite from the bytes of a binary file: 4 bytes per sprite followed by the end byte 0x80
+func NewMetaspriteFromBytes(bytes []byte) (*Metasprite, error) {
+	if len(bytes)%4 != 1 {
+		return nil, fmt.Errorf("a metasprite has 4 bytes per sprite followed by the end byte 0x80, not %d bytes", len(bytes))
+	}
+	if end := bytes[len(bytes)-1]; end != 0x80 {
+		return nil, fmt.Errorf("a metasprite ends with the byte 0x80, not 0x%02x", end)
+	}
+
 	metasrp := new(Metasprite)
 	for i := 0; i < len(bytes)-1; i += 4 {
 		metasrp.sprites = append(metasrp.sprites, &Sprite{
@@ -69,6 +91,197 @@ func (metasprite *Metasprite) To8x16() {
 	}
 }
 
+//Mirror returns a new metasprite that is the horizontal mirror of this one around the vertical axis at x = pivot
+func (metasprite *Metasprite) Mirror(pivot int) (*Metasprite, error) {
+	mirrored := new(Metasprite)
+	for i, spr := range metasprite.sprites {
+		x := 2*pivot - int(spr.X) - 8
+		if x < math.MinInt8 || x > math.MaxInt8 {
+			return nil, fmt.Errorf("sprite %d: mirrored X %d is out of range [%d,%d]", i, x, math.MinInt8, math.MaxInt8)
+		}
+
+		mirrored.sprites = append(mirrored.sprites, &Sprite{
+			X:   int8(x),
+			Y:   spr.Y,
+			Opt: spr.Opt ^ spriteMirrorOpt,
+			Idx: spr.Idx,
+		})
+	}
+
+	return mirrored, nil
+}
+
+//Flip returns a new metasprite that is the vertical mirror of this one around the horizontal axis at y = pivot.
+//A flipped 8x16 sprite has its top and bottom tiles swapped by the PPU, so only its position must follow the 16 pixels height.
+func (metasprite *Metasprite) Flip(pivot int, tiledim TileDimension) (*Metasprite, error) {
+	flipped := new(Metasprite)
+	for i, spr := range metasprite.sprites {
+		y := 2*pivot - int(spr.Y) - tiledim.Height()
+		if y < math.MinInt8 || y > math.MaxInt8 {
+			return nil, fmt.Errorf("sprite %d: flipped Y %d is out of range [%d,%d]", i, y, math.MinInt8, math.MaxInt8)
+		}
+
+		flipped.sprites = append(flipped.sprites, &Sprite{
+			X:   spr.X,
+			Y:   int8(y),
+			Opt: spr.Opt ^ spriteFlipOpt,
+			Idx: spr.Idx,
+		})
+	}
+
+	return flipped, nil
+}
+
+//SetBehind sets or clears the priority bit of all sprites, drawing them behind or in front of the background
+func (metasprite *Metasprite) SetBehind(behind bool) {
+	for _, spr := range metasprite.sprites {
+		if behind {
+			spr.Opt |= spritePriorityOpt
+		} else {
+			spr.Opt &^= spritePriorityOpt
+		}
+	}
+}
+
+//MarkBehind sets the priority bit of the sprites whose tiles are marked by a mask from NewTileMaskFromPNG.
+//It must be called before To8x16, when the sprites still follow the tileset built by NewTilesetFromPNG.
+//For 8x16 tiles, a sprite is also behind when the tile below it is marked.
+func (metasprite *Metasprite) MarkBehind(mask []bool, tiledim TileDimension) {
+	for _, spr := range metasprite.sprites {
+		idx := int(spr.Idx)
+		if mask[idx] || (tiledim == Tile8x16 && idx+TilesetMaxCols < len(mask) && mask[idx+TilesetMaxCols]) {
+			spr.Opt |= spritePriorityOpt
+		}
+	}
+}
+
+//MarkPalettes sets the palette of each sprite from the palette of its tile in the tileset built by NewTilesetFromPNG, called before To8x16
+func (metasprite *Metasprite) MarkPalettes(palettes []byte) {
+	for _, spr := range metasprite.sprites {
+		spr.Opt = spr.Opt&^spritePaletteOpt | palettes[spr.Idx]&spritePaletteOpt
+	}
+}
+
+//ToggleBehind toggles the priority bit of all sprites
+func (metasprite *Metasprite) ToggleBehind() {
+	for _, spr := range metasprite.sprites {
+		spr.Opt ^= spritePriorityOpt
+	}
+}
+
+//SetPalette sets the palette of all sprites
+func (metasprite *Metasprite) SetPalette(pal byte) error {
+	if pal > spritePaletteOpt {
+		return fmt.Errorf("palette %d is out of range [0,%d]", pal, spritePaletteOpt)
+	}
+	for _, spr := range metasprite.sprites {
+		spr.Opt = spr.Opt&^spritePaletteOpt | pal
+	}
+	return nil
+}
+
+//Translate returns a new metasprite with all sprites moved by (dx,dy)
+func (metasprite *Metasprite) Translate(dx, dy int) (*Metasprite, error) {
+	translated := new(Metasprite)
+	for i, spr := range metasprite.sprites {
+		x, y := int(spr.X)+dx, int(spr.Y)+dy
+		if x < math.MinInt8 || x > math.MaxInt8 || y < math.MinInt8 || y > math.MaxInt8 {
+			return nil, fmt.Errorf("sprite %d: translated (%d,%d) is out of range [%d,%d]", i, x, y, math.MinInt8, math.MaxInt8)
+		}
+
+		translated.sprites = append(translated.sprites, &Sprite{
+			X:   int8(x),
+			Y:   int8(y),
+			Opt: spr.Opt,
+			Idx: spr.Idx,
+		})
+	}
+
+	return translated, nil
+}
+
+//Delete returns a new metasprite without the sprites at the given positions
+func (metasprite *Metasprite) Delete(positions ...int) (*Metasprite, error) {
+	deleted := make([]bool, metasprite.Size())
+	for _, pos := range positions {
+		if pos < 0 || pos >= metasprite.Size() {
+			return nil, fmt.Errorf("sprite %d does not exist, the metasprite has %d sprites", pos, metasprite.Size())
+		}
+		deleted[pos] = true
+	}
+
+	remaining := new(Metasprite)
+	for i, spr := range metasprite.sprites {
+		if !deleted[i] {
+			remaining.sprites = append(remaining.sprites, spr)
+		}
+	}
+
+	return remaining, nil
+}
+
+//Reorder returns a new metasprite with the sprites at the given positions moved to the beginning, in the given order.
+//The other sprites follow them in their current order.
+func (metasprite *Metasprite) Reorder(positions ...int) (*Metasprite, error) {
+	moved := make([]bool, metasprite.Size())
+	reordered := new(Metasprite)
+	for _, pos := range positions {
+		if pos < 0 || pos >= metasprite.Size() {
+			return nil, fmt.Errorf("sprite %d does not exist, the metasprite has %d sprites", pos, metasprite.Size())
+		}
+		if moved[pos] {
+			return nil, fmt.Errorf("sprite %d is repeated", pos)
+		}
+		moved[pos] = true
+		reordered.sprites = append(reordered.sprites, metasprite.sprites[pos])
+	}
+
+	for i, spr := range metasprite.sprites {
+		if !moved[i] {
+			reordered.sprites = append(reordered.sprites, spr)
+		}
+	}
+
+	return reordered, nil
+}
+
+//Sort returns a new metasprite with the sprites sorted for drawing, keeping the order of the sprites that compare equal.
+//The PPU draws the first sprite over the others, so:
+//SortByY sorts from top to bottom, then left to right;
+//SortByX sorts from left to right, then top to bottom;
+//SortByFront moves the sprites in front of the background before the sprites behind it, so no sprite behind the background hides them.
+func (metasprite *Metasprite) Sort(order string) (*Metasprite, error) {
+	sorted := &Metasprite{sprites: append([]*Sprite{}, metasprite.sprites...)}
+
+	var less func(a, b *Sprite) bool
+	switch order {
+	case SortByY:
+		less = func(a, b *Sprite) bool { return a.Y < b.Y || (a.Y == b.Y && a.X < b.X) }
+	case SortByX:
+		less = func(a, b *Sprite) bool { return a.X < b.X || (a.X == b.X && a.Y < b.Y) }
+	case SortByFront:
+		less = func(a, b *Sprite) bool { return !a.Behind() && b.Behind() }
+	default:
+		return nil, fmt.Errorf("unknown sort order '%s'", order)
+	}
+
+	sort.SliceStable(sorted.sprites, func(i, j int) bool { return less(sorted.sprites[i], sorted.sprites[j]) })
+
+	return sorted, nil
+}
+
+//SwapTiles exchanges the tiles used by the sprites, following a Tileset.Swap of the tiles i and j.
+//For 8x16 tiles, i and j are the top tiles of the swapped pairs.
+func (metasprite *Metasprite) SwapTiles(i, j byte) {
+	for _, spr := range metasprite.sprites {
+		if spr.Idx == i {
+			spr.Idx = j
+		} else if spr.Idx == j {
+			spr.Idx = i
+		}
+	}
+}
+
 //Merge merge a metasprite into this one
 func (metasprite *Metasprite) Merge(other *Metasprite) {
 	metasprite.sprites = append(metasprite.sprites, other.sprites...)
@@ -76,22 +289,21 @@ func (metasprite *Metasprite) Merge(other *Metasprite) {
 
 //WriteC write the metasprite to a .c and .h files
 func (metasprite *Metasprite) WriteC(filename string) error {
-	cfilename := changeFileExtension(filename, "c")
+	cfilename := ChangeFileExtension(filename, "c")
 	cfile, err := os.OpenFile(cfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
 	defer cfile.Close()
 
-	hfilename := changeFileExtension(filename, "h")
+	hfilename := ChangeFileExtension(filename, "h")
 	hfile, err := os.OpenFile(hfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
 	defer hfile.Close()
 
-	varname := filename[strings.LastIndex(filename, "/")+1 : strings.LastIndex(filename, ".")]
-	varname = strings.Replace(varname, "-", "_", -1)
+	varname := labelName(filename)
 	fmt.Fprintf(hfile, "extern char %s[%d];\n", varname, metasprite.Size()*4+1)
 	fmt.Fprintf(cfile, "const char %s[] = {\n", varname)
 	for _, spr := range metasprite.sprites {
@@ -105,15 +317,14 @@ func (metasprite *Metasprite) WriteC(filename string) error {
 
 //WriteAsm write the metasprite to a .inc file
 func (metasprite *Metasprite) WriteAsm(filename string) error {
-	asmfilename := changeFileExtension(filename, "inc")
+	asmfilename := ChangeFileExtension(filename, "inc")
 	asmfile, err := os.OpenFile(asmfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
 	defer asmfile.Close()
 
-	varname := filename[strings.LastIndex(filename, "/")+1 : strings.LastIndex(filename, ".")]
-	varname = strings.Replace(varname, "-", "_", -1)
+	varname := labelName(filename)
 	fmt.Fprintf(asmfile, "%s:\n", varname)
 	for _, spr := range metasprite.sprites {
 		fmt.Fprintf(asmfile, "\t.byte %s\n", spr.String())
@@ -125,7 +336,7 @@ func (metasprite *Metasprite) WriteAsm(filename string) error {
 
 //WriteBin write the metasprite to a .bin file
 func (metasprite *Metasprite) WriteBin(filename string) error {
-	binfilename := changeFileExtension(filename, "bin")
+	binfilename := ChangeFileExtension(filename, "bin")
 	binfile, err := os.OpenFile(binfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
@@ -142,6 +353,35 @@ func (metasprite *Metasprite) WriteBin(filename string) error {
 	return err
 }
 
+//Bounds returns the rectangle covered by the sprites, given the dimension of their tiles
+func (metasprite *Metasprite) Bounds(tiledim TileDimension) image.Rectangle {
+	var bounds image.Rectangle
+	for _, spr := range metasprite.sprites {
+		bounds = bounds.Union(image.Rect(int(spr.X), int(spr.Y), int(spr.X)+8, int(spr.Y)+tiledim.Height()))
+	}
+
+	return bounds
+}
+
+//ScanlinePeak returns the highest amount of sprites sharing a scanline, given the dimension of their tiles
+func (metasprite *Metasprite) ScanlinePeak(tiledim TileDimension) int {
+	bounds := metasprite.Bounds(tiledim)
+	peak := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		count := 0
+		for _, spr := range metasprite.sprites {
+			if y >= int(spr.Y) && y < int(spr.Y)+tiledim.Height() {
+				count++
+			}
+		}
+		if count > peak {
+			peak = count
+		}
+	}
+
+	return peak
+}
+
 //At returns a sprite at position i
 func (metasprite *Metasprite) At(i int) *Sprite {
 	return metasprite.sprites[i]
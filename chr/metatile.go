@@ -0,0 +1,145 @@
+package chr
+
+import (
+	"fmt"
+)
+
+//Metatile is a square block of 2x2 or 4x4 tiles, a common unit of NES level maps
+type Metatile struct {
+	Tiles []byte // row by row
+	Attr  byte   // the palette of a 2x2 block, the attribute byte of a 4x4 block
+}
+
+//Metatiles are the blocks without duplicates of a nametable, with the map of the blocks
+type Metatiles struct {
+	size   int // in tiles
+	blocks []Metatile
+	width  int // in blocks
+	height int // in blocks
+	blkmap []byte
+}
+
+//NewMetatiles slices a nametable into blocks of size x size tiles, 2 or 4, removing the duplicated blocks.
+//The blocks at the right and bottom edges are completed with the tile 0 and the palette 0, e.g. the 4x4 blocks of the last row of a 32x30 nametable.
+//There may be at most 256 different blocks.
+func NewMetatiles(nametable *Nametable, size int) (*Metatiles, error) {
+	if size != 2 && size != 4 {
+		return nil, fmt.Errorf("metatiles must have 2x2 or 4x4 tiles, not %dx%d", size, size)
+	}
+
+	metatiles := &Metatiles{size: size, width: (nametable.width + size - 1) / size, height: (nametable.height + size - 1) / size}
+	index := make(map[string]int)
+	for by := 0; by < metatiles.height; by++ {
+		for bx := 0; bx < metatiles.width; bx++ {
+			block := Metatile{}
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					var cell NametableCell
+					if bx*size+x < nametable.width && by*size+y < nametable.height {
+						cell = nametable.At(bx*size+x, by*size+y)
+					}
+					block.Tiles = append(block.Tiles, cell.Idx)
+					if x%2 == 0 && y%2 == 0 {
+						block.Attr |= cell.Pal << uint((y/2)*4+(x/2)*2)
+					}
+				}
+			}
+
+			key := fmt.Sprintf("%v/%d", block.Tiles, block.Attr)
+			i, found := index[key]
+			if !found {
+				if len(metatiles.blocks) == 256 {
+					return nil, fmt.Errorf("block at (%d,%d) pixels exceeds the 256 different metatiles", bx*size*8, by*size*8)
+				}
+				i = len(metatiles.blocks)
+				index[key] = i
+				metatiles.blocks = append(metatiles.blocks, block)
+			}
+			metatiles.blkmap = append(metatiles.blkmap, byte(i))
+		}
+	}
+
+	return metatiles, nil
+}
+
+//Size returns the amount of different blocks
+func (metatiles *Metatiles) Size() int {
+	return len(metatiles.blocks)
+}
+
+//At returns the block at position i
+func (metatiles *Metatiles) At(i int) Metatile {
+	return metatiles.blocks[i]
+}
+
+//Width returns the width of the map in blocks
+func (metatiles *Metatiles) Width() int {
+	return metatiles.width
+}
+
+//Height returns the height of the map in blocks
+func (metatiles *Metatiles) Height() int {
+	return metatiles.height
+}
+
+//Map returns the index of each block of the map, row by row
+func (metatiles *Metatiles) Map() []byte {
+	return metatiles.blkmap
+}
+
+//Tables returns the block definition tables, one table per tile position of the blocks, row by row, followed by the table of attributes.
+//The i-th byte of each table belongs to the i-th block.
+func (metatiles *Metatiles) Tables() [][]byte {
+	tables := make([][]byte, metatiles.size*metatiles.size+1)
+	for _, block := range metatiles.blocks {
+		for pos, idx := range block.Tiles {
+			tables[pos] = append(tables[pos], idx)
+		}
+		tables[len(tables)-1] = append(tables[len(tables)-1], block.Attr)
+	}
+	return tables
+}
+
+//TableNames returns the suffixes of the labels of the tables returned by Tables
+func (metatiles *Metatiles) TableNames() []string {
+	var names []string
+	if metatiles.size == 2 {
+		names = []string{"_tl", "_tr", "_bl", "_br", "_pal"}
+	} else {
+		for pos := 0; pos < metatiles.size*metatiles.size; pos++ {
+			names = append(names, fmt.Sprintf("_tile%d", pos))
+		}
+		names = append(names, "_attr")
+	}
+	return names
+}
+
+//WriteC write the block definition tables and the map to a .c and .h files, the map labeled with the suffix "_map"
+func (metatiles *Metatiles) WriteC(filename string) error {
+	labels, tables := metatiles.labeledTables(filename)
+	return writeTablesC(filename, labels, tables)
+}
+
+//WriteAsm write the block definition tables and the map to a .inc file, the map labeled with the suffix "_map"
+func (metatiles *Metatiles) WriteAsm(filename string) error {
+	labels, tables := metatiles.labeledTables(filename)
+	return writeTablesAsm(filename, labels, tables)
+}
+
+//WriteBin write the block definition tables followed by the map to a .bin file
+func (metatiles *Metatiles) WriteBin(filename string) error {
+	var bytes []byte
+	for _, table := range metatiles.Tables() {
+		bytes = append(bytes, table...)
+	}
+	return writeBytesBin(filename, append(bytes, metatiles.blkmap...))
+}
+
+func (metatiles *Metatiles) labeledTables(filename string) ([]string, [][]byte) {
+	varname := labelName(filename)
+	var labels []string
+	for _, name := range metatiles.TableNames() {
+		labels = append(labels, varname+name)
+	}
+	return append(labels, varname+"_map"), append(metatiles.Tables(), metatiles.blkmap)
+}
@@ -0,0 +1,250 @@
+package chr
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+//NametableCell is a tile of a nametable with the palette it is drawn with
+type NametableCell struct {
+	Idx byte
+	Pal byte
+}
+
+//Nametable is a map of background tiles, of any dimension, with the palette of each tile
+type Nametable struct {
+	width       int
+	height      int
+	cells       []NametableCell
+	compression string
+	columns     bool
+}
+
+//NewNametable builds a nametable with the dimension in tiles, filled with the tile 0 and the palette 0
+func NewNametable(width, height int) *Nametable {
+	return &Nametable{width: width, height: height, cells: make([]NametableCell, width*height)}
+}
+
+//Width returns the width in tiles
+func (nametable *Nametable) Width() int {
+	return nametable.width
+}
+
+//Height returns the height in tiles
+func (nametable *Nametable) Height() int {
+	return nametable.height
+}
+
+//At returns the cell at the tile (x,y)
+func (nametable *Nametable) At(x, y int) NametableCell {
+	return nametable.cells[y*nametable.width+x]
+}
+
+//Set changes the cell at the tile (x,y)
+func (nametable *Nametable) Set(x, y int, cell NametableCell) {
+	nametable.cells[y*nametable.width+x] = cell
+}
+
+//Attributes returns the attribute table: one byte per area of 4x4 tiles, row by row, with the palettes of its 2x2 tiles blocks
+//from the lowest bits as top left, top right, bottom left and bottom right.
+//The tiles of a block must share the palette, as the PPU allows only 1 palette per block.
+func (nametable *Nametable) Attributes() ([]byte, error) {
+	cols, rows := (nametable.width+3)/4, (nametable.height+3)/4
+	attrs := make([]byte, cols*rows)
+
+	for by := 0; by < (nametable.height+1)/2; by++ {
+		for bx := 0; bx < (nametable.width+1)/2; bx++ {
+			pal := nametable.At(bx*2, by*2).Pal
+			for y := by * 2; y < by*2+2 && y < nametable.height; y++ {
+				for x := bx * 2; x < bx*2+2 && x < nametable.width; x++ {
+					if other := nametable.At(x, y).Pal; other != pal {
+						return nil, fmt.Errorf("tile (%d,%d) has the palette %d but the tile (%d,%d) of the same 16x16 block has the palette %d", x, y, other, bx*2, by*2, pal)
+					}
+				}
+			}
+
+			shift := uint((by%2)*4 + (bx%2)*2)
+			attrs[(by/2)*cols+bx/2] |= (pal & 3) << shift
+		}
+	}
+
+	return attrs, nil
+}
+
+//Bytes transform the nametable into an array of bytes: the tiles row by row followed by the attribute table.
+//A nametable of 32x30 tiles has the 1024 bytes loaded into the PPU.
+func (nametable *Nametable) Bytes() ([]byte, error) {
+	attrs, err := nametable.Attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	bytes := make([]byte, 0, len(nametable.cells)+len(attrs))
+	for _, cell := range nametable.cells {
+		bytes = append(bytes, cell.Idx)
+	}
+
+	return append(bytes, attrs...), nil
+}
+
+//ColumnBytes transform the nametable into an array of bytes: the tiles column by column followed by the attribute table column by column.
+//Each column of tiles, or attributes, is streamed when scrolling horizontally.
+func (nametable *Nametable) ColumnBytes() ([]byte, error) {
+	attrs, err := nametable.Attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	bytes := make([]byte, 0, len(nametable.cells)+len(attrs))
+	for x := 0; x < nametable.width; x++ {
+		for y := 0; y < nametable.height; y++ {
+			bytes = append(bytes, nametable.At(x, y).Idx)
+		}
+	}
+
+	cols, rows := (nametable.width+3)/4, (nametable.height+3)/4
+	for x := 0; x < cols; x++ {
+		for y := 0; y < rows; y++ {
+			bytes = append(bytes, attrs[y*cols+x])
+		}
+	}
+
+	return bytes, nil
+}
+
+//SetColumnOrder sets if the bytes written to files are the ColumnBytes instead of the Bytes
+func (nametable *Nametable) SetColumnOrder(columns bool) {
+	nametable.columns = columns
+}
+
+//SetCompression sets the compression, one of CompressNone, CompressRLE or CompressLZ4, of the bytes written to files
+func (nametable *Nametable) SetCompression(compression string) {
+	nametable.compression = compression
+}
+
+//WriteC write the nametable to a .c and .h files
+func (nametable *Nametable) WriteC(filename string) error {
+	bytes, err := nametable.compressedBytes()
+	if err != nil {
+		return err
+	}
+	return writeBytesC(filename, bytes)
+}
+
+//WriteAsm write the nametable to a .inc file
+func (nametable *Nametable) WriteAsm(filename string) error {
+	bytes, err := nametable.compressedBytes()
+	if err != nil {
+		return err
+	}
+	return writeBytesAsm(filename, bytes)
+}
+
+//WriteBin write the nametable to a .bin file
+func (nametable *Nametable) WriteBin(filename string) error {
+	bytes, err := nametable.compressedBytes()
+	if err != nil {
+		return err
+	}
+	return writeBytesBin(filename, bytes)
+}
+
+func (nametable *Nametable) compressedBytes() ([]byte, error) {
+	bytes, err := nametable.Bytes()
+	if nametable.columns {
+		bytes, err = nametable.ColumnBytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Compress(bytes, nametable.compression)
+}
+
+//writeBytesC writes an array of bytes, labeled by the file name, to a .c and .h files
+func writeBytesC(filename string, bytes []byte) error {
+	return writeTablesC(filename, []string{labelName(filename)}, [][]byte{bytes})
+}
+
+//writeTablesC writes arrays of bytes, each one with its label, to a .c and .h files
+func writeTablesC(filename string, labels []string, tables [][]byte) error {
+	cfilename := ChangeFileExtension(filename, "c")
+	cfile, err := os.OpenFile(cfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer cfile.Close()
+
+	hfilename := ChangeFileExtension(filename, "h")
+	hfile, err := os.OpenFile(hfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer hfile.Close()
+
+	for i, label := range labels {
+		fmt.Fprintf(hfile, "extern char %s[%d];\n", label, len(tables[i]))
+		fmt.Fprintf(cfile, "const char %s[] = {\n", label)
+		for _, line := range hexLines(tables[i], "0x%02x") {
+			fmt.Fprintf(cfile, "\t%s,\n", line)
+		}
+		fmt.Fprintln(cfile, "};")
+	}
+
+	return nil
+}
+
+//writeBytesAsm writes an array of bytes, labeled by the file name, to a .inc file
+func writeBytesAsm(filename string, bytes []byte) error {
+	return writeTablesAsm(filename, []string{labelName(filename)}, [][]byte{bytes})
+}
+
+//writeTablesAsm writes arrays of bytes, each one with its label, to a .inc file
+func writeTablesAsm(filename string, labels []string, tables [][]byte) error {
+	asmfilename := ChangeFileExtension(filename, "inc")
+	asmfile, err := os.OpenFile(asmfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer asmfile.Close()
+
+	for i, label := range labels {
+		fmt.Fprintf(asmfile, "%s:\n", label)
+		for _, line := range hexLines(tables[i], "$%02x") {
+			fmt.Fprintf(asmfile, "\t.byte %s\n", line)
+		}
+	}
+
+	return nil
+}
+
+//writeBytesBin writes an array of bytes to a .bin file
+func writeBytesBin(filename string, bytes []byte) error {
+	return writeBytesBinExt(filename, "bin", bytes)
+}
+
+//writeBytesBinExt writes an array of bytes to a file with the given extension
+func writeBytesBinExt(filename, extension string, bytes []byte) error {
+	binfilename := ChangeFileExtension(filename, extension)
+	binfile, err := os.OpenFile(binfilename, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer binfile.Close()
+
+	_, err = binfile.Write(bytes)
+	return err
+}
+
+//hexLines formats the bytes 16 per line, separated by commas
+func hexLines(bytes []byte, format string) []string {
+	var lines []string
+	for i := 0; i < len(bytes); i += 16 {
+		var hex []string
+		for j := i; j < i+16 && j < len(bytes); j++ {
+			hex = append(hex, fmt.Sprintf(format, bytes[j]))
+		}
+		lines = append(lines, strings.Join(hex, ", "))
+	}
+	return lines
+}
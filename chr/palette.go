@@ -0,0 +1,96 @@
+package chr
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+)
+
+//PalettesSize is the size in bytes of a palette file, with 4 palettes of 4 colors
+const PalettesSize = 16
+
+//NESPalette has the RGB colors of the 64 color indexes of the NES PPU
+var NESPalette = color.Palette{
+	rgb(0x54, 0x54, 0x54), rgb(0x00, 0x1e, 0x74), rgb(0x08, 0x10, 0x90), rgb(0x30, 0x00, 0x88),
+	rgb(0x44, 0x00, 0x64), rgb(0x5c, 0x00, 0x30), rgb(0x54, 0x04, 0x00), rgb(0x3c, 0x18, 0x00),
+	rgb(0x20, 0x2a, 0x00), rgb(0x08, 0x3a, 0x00), rgb(0x00, 0x40, 0x00), rgb(0x00, 0x3c, 0x00),
+	rgb(0x00, 0x32, 0x3c), rgb(0x00, 0x00, 0x00), rgb(0x00, 0x00, 0x00), rgb(0x00, 0x00, 0x00),
+	rgb(0x98, 0x96, 0x98), rgb(0x08, 0x4c, 0xc4), rgb(0x30, 0x32, 0xec), rgb(0x5c, 0x1e, 0xe4),
+	rgb(0x88, 0x14, 0xb0), rgb(0xa0, 0x14, 0x64), rgb(0x98, 0x22, 0x20), rgb(0x78, 0x3c, 0x00),
+	rgb(0x54, 0x5a, 0x00), rgb(0x28, 0x72, 0x00), rgb(0x08, 0x7c, 0x00), rgb(0x00, 0x76, 0x28),
+	rgb(0x00, 0x66, 0x78), rgb(0x00, 0x00, 0x00), rgb(0x00, 0x00, 0x00), rgb(0x00, 0x00, 0x00),
+	rgb(0xec, 0xee, 0xec), rgb(0x4c, 0x9a, 0xec), rgb(0x78, 0x7c, 0xec), rgb(0xb0, 0x62, 0xec),
+	rgb(0xe4, 0x54, 0xec), rgb(0xec, 0x58, 0xb4), rgb(0xec, 0x6a, 0x64), rgb(0xd4, 0x88, 0x20),
+	rgb(0xa0, 0xaa, 0x00), rgb(0x74, 0xc4, 0x00), rgb(0x4c, 0xd0, 0x20), rgb(0x38, 0xcc, 0x6c),
+	rgb(0x38, 0xb4, 0xcc), rgb(0x3c, 0x3c, 0x3c), rgb(0x00, 0x00, 0x00), rgb(0x00, 0x00, 0x00),
+	rgb(0xec, 0xee, 0xec), rgb(0xa8, 0xcc, 0xec), rgb(0xbc, 0xbc, 0xec), rgb(0xd4, 0xb2, 0xec),
+	rgb(0xec, 0xae, 0xec), rgb(0xec, 0xae, 0xd4), rgb(0xec, 0xb4, 0xb0), rgb(0xe4, 0xc4, 0x90),
+	rgb(0xcc, 0xd2, 0x78), rgb(0xb4, 0xde, 0x78), rgb(0xa8, 0xe2, 0x90), rgb(0x98, 0xe2, 0xb4),
+	rgb(0xa0, 0xd6, 0xe4), rgb(0xa0, 0xa2, 0xa0), rgb(0x00, 0x00, 0x00), rgb(0x00, 0x00, 0x00),
+}
+
+//Palettes are the 4 palettes of the sprites, or of the background, each one with 4 color indexes of the NESPalette.
+//The color 0 of the 1st palette is the universal background color.
+type Palettes [4][4]byte
+
+//DefaultPalettes are used when no palette file is given: grays, reds, greens and blues over black
+var DefaultPalettes = Palettes{
+	{0x0f, 0x00, 0x10, 0x30},
+	{0x0f, 0x06, 0x16, 0x26},
+	{0x0f, 0x09, 0x19, 0x29},
+	{0x0f, 0x01, 0x11, 0x21},
+}
+
+//NewPalettesFromFile reads the palettes from a 16 bytes file, as loaded into the PPU
+func NewPalettesFromFile(palfile *os.File) (Palettes, error) {
+	var palettes Palettes
+	bytes := make([]byte, PalettesSize+1)
+	n, err := io.ReadFull(palfile, bytes)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return palettes, err
+	}
+	if n != PalettesSize {
+		return palettes, fmt.Errorf("palette file must have %d bytes", PalettesSize)
+	}
+
+	for i, idx := range bytes[:PalettesSize] {
+		if int(idx) >= len(NESPalette) {
+			return palettes, fmt.Errorf("color 0x%02x at byte %d is out of range [0x00,0x3f]", idx, i)
+		}
+		palettes[i/4][i%4] = idx
+	}
+
+	return palettes, nil
+}
+
+//Bytes transform the palettes into an array of 16 bytes, as loaded into the PPU
+func (palettes Palettes) Bytes() []byte {
+	var bytes []byte
+	for _, palette := range palettes {
+		bytes = append(bytes, palette[:]...)
+	}
+	return bytes
+}
+
+//Color returns the NESPalette index of a color of a palette
+func (palettes Palettes) Color(pal, color byte) byte {
+	if color == 0 {
+		return palettes[0][0]
+	}
+	return palettes[pal&spritePaletteOpt][color&3]
+}
+
+//Write writes the palettes to a .pal file of 16 bytes
+func (palettes Palettes) Write(filename string) error {
+	return writeBytesBinExt(filename, "pal", palettes.Bytes())
+}
+
+//WriteSubPalette writes the 4 color indexes of a sub-palette to a .pal file
+func WriteSubPalette(filename string, subpal [SubPaletteSize]byte) error {
+	return writeBytesBinExt(filename, "pal", subpal[:])
+}
+
+func rgb(r, g, b uint8) color.RGBA {
+	return color.RGBA{r, g, b, 0xff}
+}
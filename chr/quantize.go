@@ -0,0 +1,255 @@
+package chr
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+//Dithering of the quantization
+const (
+	DitherNone  = "none"
+	DitherBayer = "bayer"
+	DitherFloyd = "floyd"
+)
+
+//SubPaletteSize is the amount of colors of a sub-palette
+const SubPaletteSize = 4
+
+//nesDuplicates are the color indexes of the NESPalette never chosen, as they repeat other colors or, as 0x0d, are unsafe
+var nesDuplicates = map[byte]bool{
+	0x0d: true, 0x0e: true, 0x1d: true, 0x1e: true, 0x1f: true,
+	0x20: true, 0x2e: true, 0x2f: true, 0x3e: true, 0x3f: true,
+}
+
+//bayer4x4 is the threshold matrix of the ordered dithering
+var bayer4x4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+//bayerSpread is how far, in each RGB channel, the ordered dithering moves a color
+const bayerSpread = 64
+
+//rgbf is a color with float channels, to accumulate the errors of the dithering
+type rgbf struct {
+	r, g, b float64
+}
+
+func newRGBF(c color.Color) rgbf {
+	r, g, b, _ := c.RGBA()
+	return rgbf{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+}
+
+//distance is the squared distance between 2 colors, weighted by how sensitive the eye is to each channel
+func (c rgbf) distance(other rgbf) float64 {
+	dr, dg, db := c.r-other.r, c.g-other.g, c.b-other.b
+	return 2*dr*dr + 4*dg*dg + 3*db*db
+}
+
+func (c rgbf) luminance() float64 {
+	return 0.299*c.r + 0.587*c.g + 0.114*c.b
+}
+
+//opaque tells if a pixel is drawn, the transparent pixels always getting the color 0
+func opaque(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a >= 0x8000
+}
+
+//Transparent returns true if any pixel of the image is transparent
+func Transparent(img image.Image) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !opaque(img.At(x, y)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//NearestNESColor returns the color index of the NESPalette nearest to a color
+func NearestNESColor(c color.Color) byte {
+	return nearestNESColor(newRGBF(c), nil)
+}
+
+func nearestNESColor(c rgbf, used map[byte]bool) byte {
+	best, bestDist := byte(0x0f), -1.0
+	for i, nes := range NESPalette {
+		idx := byte(i)
+		if nesDuplicates[idx] || used[idx] {
+			continue
+		}
+		if dist := c.distance(newRGBF(nes)); bestDist < 0 || dist < bestDist {
+			best, bestDist = idx, dist
+		}
+	}
+	return best
+}
+
+//NewSubPaletteFromImage chooses the colors of the NESPalette that best represent the opaque pixels of an image, from the darkest to the lightest.
+//For sprites, the color 0 is transparent, so it is left as 0x0f and only 3 colors are chosen.
+func NewSubPaletteFromImage(img image.Image, sprite bool) [SubPaletteSize]byte {
+	counts := make(map[rgbf]int)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if c := img.At(x, y); opaque(c) {
+				counts[newRGBF(c)]++
+			}
+		}
+	}
+
+	first := 0
+	if sprite {
+		first = 1
+	}
+	centroids := kmeans(counts, SubPaletteSize-first)
+
+	subpal := [SubPaletteSize]byte{0x0f, 0x0f, 0x0f, 0x0f}
+	used := make(map[byte]bool)
+	for i, centroid := range centroids {
+		subpal[first+i] = nearestNESColor(centroid, used)
+		used[subpal[first+i]] = true
+	}
+
+	return subpal
+}
+
+//kmeans groups the colors, weighted by their counts, into at most k clusters and returns their centers from the darkest to the lightest
+func kmeans(counts map[rgbf]int, k int) []rgbf {
+	var colors []rgbf
+	for c := range counts {
+		colors = append(colors, c)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if li, lj := colors[i].luminance(), colors[j].luminance(); li != lj {
+			return li < lj
+		}
+		return colors[i].r+2*colors[i].g+3*colors[i].b < colors[j].r+2*colors[j].g+3*colors[j].b
+	})
+	if len(colors) <= k {
+		return colors
+	}
+
+	// start from the luminance quantiles, so the result does not depend on chance
+	centroids := make([]rgbf, k)
+	for i := range centroids {
+		centroids[i] = colors[(2*i+1)*len(colors)/(2*k)]
+	}
+
+	for iter := 0; iter < 16; iter++ {
+		sums := make([]rgbf, k)
+		weights := make([]float64, k)
+		for _, c := range colors {
+			nearest := 0
+			for i := range centroids {
+				if c.distance(centroids[i]) < c.distance(centroids[nearest]) {
+					nearest = i
+				}
+			}
+			w := float64(counts[c])
+			sums[nearest] = rgbf{sums[nearest].r + c.r*w, sums[nearest].g + c.g*w, sums[nearest].b + c.b*w}
+			weights[nearest] += w
+		}
+
+		changed := false
+		for i := range centroids {
+			if weights[i] == 0 {
+				continue
+			}
+			centroid := rgbf{sums[i].r / weights[i], sums[i].g / weights[i], sums[i].b / weights[i]}
+			if centroid != centroids[i] {
+				centroids[i], changed = centroid, true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	sort.Slice(centroids, func(i, j int) bool { return centroids[i].luminance() < centroids[j].luminance() })
+	return centroids
+}
+
+//Quantize reduces an image to the colors of a sub-palette of the NESPalette with a dithering, returning an image indexed by the sub-palette.
+//The transparent pixels get the color 0. For sprites, the color 0 is transparent, so the opaque pixels get only the colors [1,3].
+func Quantize(img image.Image, subpal [SubPaletteSize]byte, dither string, sprite bool) (*image.Paletted, error) {
+	for _, idx := range subpal {
+		if int(idx) >= len(NESPalette) {
+			return nil, fmt.Errorf("color 0x%02x is out of range [0x00,0x3f]", idx)
+		}
+	}
+
+	first := 0
+	if sprite {
+		first = 1
+	}
+	return quantize(img, img.Bounds(), subpal, dither, first)
+}
+
+//quantize reduces a region of an image to the colors [first,3] of a sub-palette, the transparent pixels getting the color 0
+func quantize(img image.Image, bounds image.Rectangle, subpal [SubPaletteSize]byte, dither string, first int) (*image.Paletted, error) {
+	if dither != DitherNone && dither != DitherBayer && dither != DitherFloyd {
+		return nil, fmt.Errorf("unknown dithering '%s'", dither)
+	}
+	palette := make(color.Palette, SubPaletteSize)
+	colors := make([]rgbf, SubPaletteSize)
+	for i, idx := range subpal {
+		palette[i] = NESPalette[idx]
+		colors[i] = newRGBF(NESPalette[idx])
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	errs := make([]rgbf, w*h)
+	quantized := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			if !opaque(src) {
+				continue
+			}
+
+			c := newRGBF(src)
+			switch dither {
+			case DitherBayer:
+				t := (bayer4x4[y%4][x%4]+0.5)/16 - 0.5
+				c = rgbf{c.r + t*bayerSpread, c.g + t*bayerSpread, c.b + t*bayerSpread}
+			case DitherFloyd:
+				e := errs[y*w+x]
+				c = rgbf{c.r + e.r, c.g + e.g, c.b + e.b}
+			}
+
+			nearest := first
+			for i := first; i < SubPaletteSize; i++ {
+				if c.distance(colors[i]) < c.distance(colors[nearest]) {
+					nearest = i
+				}
+			}
+			quantized.SetColorIndex(x, y, uint8(nearest))
+
+			if dither == DitherFloyd {
+				e := rgbf{c.r - colors[nearest].r, c.g - colors[nearest].g, c.b - colors[nearest].b}
+				diffuse(errs, w, h, x+1, y, e, 7.0/16)
+				diffuse(errs, w, h, x-1, y+1, e, 3.0/16)
+				diffuse(errs, w, h, x, y+1, e, 5.0/16)
+				diffuse(errs, w, h, x+1, y+1, e, 1.0/16)
+			}
+		}
+	}
+
+	return quantized, nil
+}
+
+//diffuse spreads a part of the error of a pixel to its neighbor (x,y) by the Floyd-Steinberg dithering
+func diffuse(errs []rgbf, w, h, x, y int, e rgbf, part float64) {
+	if x < 0 || x >= w || y >= h {
+		return
+	}
+	errs[y*w+x] = rgbf{errs[y*w+x].r + e.r*part, errs[y*w+x].g + e.g*part, errs[y*w+x].b + e.b*part}
+}
@@ -23,6 +23,26 @@ func (spr *Sprite) Bytes() []byte {
 	return bytes
 }
 
+//Palette returns the palette index of the sprite
+func (spr *Sprite) Palette() byte {
+	return spr.Opt & spritePaletteOpt
+}
+
+//Behind returns true if the sprite is drawn behind the background
+func (spr *Sprite) Behind() bool {
+	return spr.Opt&spritePriorityOpt != 0
+}
+
+//Mirrored returns true if the sprite is horizontally mirrored
+func (spr *Sprite) Mirrored() bool {
+	return spr.Opt&spriteMirrorOpt != 0
+}
+
+//Flipped returns true if the sprite is vertically mirrored
+func (spr *Sprite) Flipped() bool {
+	return spr.Opt&spriteFlipOpt != 0
+}
+
 func (spr *Sprite) String() string {
 	return fmt.Sprintf("%d, %d, 0x%x, %d", spr.X, spr.Y, spr.Idx, spr.Opt)
 }
@@ -0,0 +1,279 @@
+package chr
+
+import (
+	"image"
+	"sort"
+)
+
+//spritePaletteColors is the amount of colors of a sprite palette, the color 0 being transparent
+const spritePaletteColors = 3
+
+//maxPaletteCandidates limits the colors tried when choosing the colors of a palette, taking the most used ones
+const maxPaletteCandidates = 12
+
+//colorHistogram counts the pixels of each color index of the NESPalette
+type colorHistogram map[byte]int
+
+//nesDistances are the distances between the colors of the NESPalette
+var nesDistances = func() [64][64]float64 {
+	var distances [64][64]float64
+	for i := range NESPalette {
+		for j := range NESPalette {
+			distances[i][j] = newRGBF(NESPalette[i]).distance(newRGBF(NESPalette[j]))
+		}
+	}
+	return distances
+}()
+
+//TileRects returns the rectangles of the tiles of an image, row by row
+func TileRects(img image.Image, tiledim TileDimension) []image.Rectangle {
+	var rects []image.Rectangle
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += tiledim.Height() {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 8 {
+			rects = append(rects, image.Rect(x, y, x+8, y+tiledim.Height()).Intersect(bounds))
+		}
+	}
+	return rects
+}
+
+//newColorHistogram counts the opaque pixels of a region of an image by their nearest color of the NESPalette
+func newColorHistogram(img image.Image, rect image.Rectangle) colorHistogram {
+	histogram := make(colorHistogram)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if c := img.At(x, y); opaque(c) {
+				histogram[NearestNESColor(c)]++
+			}
+		}
+	}
+	return histogram
+}
+
+func (histogram colorHistogram) merge(other colorHistogram) colorHistogram {
+	merged := make(colorHistogram)
+	for idx, count := range histogram {
+		merged[idx] += count
+	}
+	for idx, count := range other {
+		merged[idx] += count
+	}
+	return merged
+}
+
+//colors returns the colors of the histogram, from the most used
+func (histogram colorHistogram) colors() []byte {
+	var colors []byte
+	for idx := range histogram {
+		colors = append(colors, idx)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if histogram[colors[i]] != histogram[colors[j]] {
+			return histogram[colors[i]] > histogram[colors[j]]
+		}
+		return colors[i] < colors[j]
+	})
+	return colors
+}
+
+//cost is the error of drawing the pixels of the histogram with the nearest colors of a palette
+func (histogram colorHistogram) cost(colors []byte) float64 {
+	total := 0.0
+	for idx, count := range histogram {
+		best := -1.0
+		for _, c := range colors {
+			if d := nesDistances[idx][c]; best < 0 || d < best {
+				best = d
+			}
+		}
+		total += best * float64(count)
+	}
+	return total
+}
+
+//bestColors returns the colors of a sprite palette drawing the histogram with the minimal error, and that error
+func (histogram colorHistogram) bestColors() ([]byte, float64) {
+	candidates := histogram.colors()
+	if len(candidates) <= spritePaletteColors {
+		return candidates, 0
+	}
+	if len(candidates) > maxPaletteCandidates {
+		candidates = candidates[:maxPaletteCandidates]
+	}
+
+	var best []byte
+	bestCost := -1.0
+	for i := 0; i < len(candidates); i++ {
+		for j := i + 1; j < len(candidates); j++ {
+			for k := j + 1; k < len(candidates); k++ {
+				colors := []byte{candidates[i], candidates[j], candidates[k]}
+				if cost := histogram.cost(colors); bestCost < 0 || cost < bestCost {
+					best, bestCost = colors, cost
+				}
+			}
+		}
+	}
+	return best, bestCost
+}
+
+type paletteCluster struct {
+	histogram colorHistogram
+	colors    []byte
+	cost      float64
+}
+
+func newPaletteCluster(histogram colorHistogram) *paletteCluster {
+	colors, cost := histogram.bestColors()
+	return &paletteCluster{histogram, colors, cost}
+}
+
+//NewSpritePalettes chooses up to 4 sprite palettes from the NESPalette drawing the tiles of the images with the minimal error.
+//Each tile is drawn with a single palette of 3 colors, the color 0 being transparent, so it is the universal background color.
+//The tiles start as groups of tiles with the same colors, then the 2 groups whose merge adds the least error are merged until there are 4 groups.
+//It returns the palettes and how many of them are used.
+func NewSpritePalettes(imgs []image.Image, tiledim TileDimension, universal byte) (Palettes, int) {
+	var clusters []*paletteCluster
+	for _, img := range imgs {
+		for _, rect := range TileRects(img, tiledim) {
+			histogram := newColorHistogram(img, rect)
+			if len(histogram) > 0 {
+				clusters = append(clusters, newPaletteCluster(histogram))
+			}
+		}
+	}
+
+	// the merge of groups whose colors fit in a palette adds no error, so it is done first to shrink the groups
+	for i := 0; i < len(clusters); i++ {
+		for j := i + 1; j < len(clusters); j++ {
+			if merged := clusters[i].histogram.merge(clusters[j].histogram); len(merged) <= spritePaletteColors {
+				clusters[i] = newPaletteCluster(merged)
+				clusters = append(clusters[:j], clusters[j+1:]...)
+				j = i
+			}
+		}
+	}
+
+	merges := make(map[[2]int]*paletteCluster)
+	mergeOf := func(i, j int) *paletteCluster {
+		key := [2]int{i, j}
+		if _, ok := merges[key]; !ok {
+			merges[key] = newPaletteCluster(clusters[i].histogram.merge(clusters[j].histogram))
+		}
+		return merges[key]
+	}
+	alive := make([]bool, len(clusters))
+	for i := range alive {
+		alive[i] = true
+	}
+	for count := len(clusters); count > len(Palettes{}); count-- {
+		bi, bj, bestCost := -1, -1, 0.0
+		for i := range clusters {
+			for j := i + 1; j < len(clusters); j++ {
+				if !alive[i] || !alive[j] {
+					continue
+				}
+				if cost := mergeOf(i, j).cost - clusters[i].cost - clusters[j].cost; bi < 0 || cost < bestCost {
+					bi, bj, bestCost = i, j, cost
+				}
+			}
+		}
+		// the merged group replaces the 1st one, so the merges cached with it are stale
+		clusters[bi] = mergeOf(bi, bj)
+		alive[bj] = false
+		for k := range clusters {
+			delete(merges, [2]int{k, bi})
+			delete(merges, [2]int{bi, k})
+		}
+	}
+
+	var chosen []*paletteCluster
+	for i, cluster := range clusters {
+		if alive[i] {
+			chosen = append(chosen, cluster)
+		}
+	}
+	// the palettes are ordered from the one drawing the most pixels
+	sort.SliceStable(chosen, func(i, j int) bool { return chosen[i].histogram.pixels() > chosen[j].histogram.pixels() })
+
+	palettes := DefaultPalettes
+	for i := range palettes {
+		palettes[i][0] = universal
+	}
+	for i, cluster := range chosen {
+		colors := append([]byte{}, cluster.colors...)
+		sort.SliceStable(colors, func(a, b int) bool {
+			return newRGBF(NESPalette[colors[a]]).luminance() < newRGBF(NESPalette[colors[b]]).luminance()
+		})
+		for c := range palettes[i][1:] {
+			// palettes with less colors repeat their last one
+			palettes[i][c+1] = colors[minInt(c, len(colors)-1)]
+		}
+	}
+	// the unused palettes repeat the 1st one, so they are never nearer to a tile than it
+	for i := len(chosen); i > 0 && i < len(palettes); i++ {
+		palettes[i] = palettes[0]
+	}
+
+	return palettes, len(chosen)
+}
+
+func (histogram colorHistogram) pixels() int {
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+	return total
+}
+
+//NearestPalette returns the sprite palette [0,used) drawing a region of an image with the minimal error,
+//and how many opaque pixels of the region do not have the exact color in it
+func (palettes Palettes) NearestPalette(img image.Image, rect image.Rectangle, used int) (byte, int) {
+	histogram := newColorHistogram(img, rect)
+	best, bestCost := 0, -1.0
+	for i := 0; i < used; i++ {
+		if cost := histogram.cost(palettes[i][1:]); bestCost < 0 || cost < bestCost {
+			best, bestCost = i, cost
+		}
+	}
+
+	missed := 0
+	for idx, count := range histogram {
+		if idx != palettes[best][1] && idx != palettes[best][2] && idx != palettes[best][3] {
+			missed += count
+		}
+	}
+	return byte(best), missed
+}
+
+//QuantizeTiles reduces each tile of an image to its nearest sprite palette with a dithering.
+//It returns the image indexed by the colors of the palettes, the color 0 being transparent, and the palette of each tile of the tileset
+//built by NewTilesetFromPNG from that image.
+func (palettes Palettes) QuantizeTiles(img image.Image, tiledim TileDimension, dither string) (*image.Paletted, []byte, error) {
+	bounds := img.Bounds()
+	quantized := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), TilePalette)
+	tilePalettes := make([]byte, TilesetMaxRows*TilesetMaxCols)
+
+	for _, rect := range TileRects(img, tiledim) {
+		pal, _ := palettes.NearestPalette(img, rect, len(palettes))
+		// the transparent color 0 is never taken by the opaque pixels
+		subpal := [SubPaletteSize]byte{palettes[pal][0], palettes[pal][1], palettes[pal][2], palettes[pal][3]}
+		tile, err := quantize(img, rect, subpal, dither, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for y := 0; y < rect.Dy(); y++ {
+			for x := 0; x < rect.Dx(); x++ {
+				quantized.SetColorIndex(rect.Min.X-bounds.Min.X+x, rect.Min.Y-bounds.Min.Y+y, tile.ColorIndexAt(x, y))
+			}
+		}
+
+		x, y := rect.Min.X-bounds.Min.X, rect.Min.Y-bounds.Min.Y
+		for ty := y; ty < y+rect.Dy(); ty += 8 {
+			row := TilesetMaxRows - bounds.Dy()/8 + ty/8
+			tilePalettes[row*TilesetMaxCols+x/8] = pal
+		}
+	}
+
+	return quantized, tilePalettes, nil
+}
@@ -0,0 +1,124 @@
+package chr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+//CharTable maps characters to the bytes encoding them, as in a .tbl file.
+//Each line of a .tbl file is HEX=CHARS, e.g. 41=A, or *HEX for the newline code, or /HEX for the end code.
+type CharTable struct {
+	codes   map[string][]byte
+	Newline []byte
+	End     []byte
+}
+
+//NewCharTable builds an empty character table
+func NewCharTable() *CharTable {
+	return &CharTable{codes: make(map[string][]byte)}
+}
+
+//ReadCharTable reads a character table from a .tbl file
+func ReadCharTable(r io.Reader) (*CharTable, error) {
+	table := NewCharTable()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		switch line[0] {
+		case '*', '/':
+			code, err := hex.DecodeString(strings.TrimSpace(line[1:]))
+			if err != nil || len(code) == 0 {
+				return nil, fmt.Errorf("line %d: invalid control code '%s'", n, line)
+			}
+			if line[0] == '*' {
+				table.Newline = code
+			} else {
+				table.End = code
+			}
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected HEX=CHARS, not '%s'", n, line)
+		}
+		code, err := hex.DecodeString(strings.TrimSpace(line[:eq]))
+		if err != nil || len(code) == 0 {
+			return nil, fmt.Errorf("line %d: invalid code '%s'", n, line[:eq])
+		}
+		chars := line[eq+1:]
+		if len(chars) == 0 {
+			return nil, fmt.Errorf("line %d: code %X has no characters", n, code)
+		}
+		table.Add(chars, code)
+	}
+
+	return table, scanner.Err()
+}
+
+//Add maps characters to the bytes encoding them
+func (table *CharTable) Add(chars string, code []byte) {
+	table.codes[chars] = code
+}
+
+//Code returns the bytes encoding the characters
+func (table *CharTable) Code(chars string) ([]byte, bool) {
+	code, ok := table.codes[chars]
+	return code, ok
+}
+
+//Chars returns the mapped characters sorted by their codes
+func (table *CharTable) Chars() []string {
+	var chars []string
+	for c := range table.codes {
+		chars = append(chars, c)
+	}
+	sort.Slice(chars, func(i, j int) bool {
+		if cmp := bytes.Compare(table.codes[chars[i]], table.codes[chars[j]]); cmp != 0 {
+			return cmp < 0
+		}
+		return chars[i] < chars[j]
+	})
+
+	return chars
+}
+
+//Write writes the character table to a .tbl file
+func (table *CharTable) Write(filename string) error {
+	tblfile, err := os.OpenFile(ChangeFileExtension(filename, "tbl"), os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer tblfile.Close()
+
+	for _, chars := range table.Chars() {
+		if _, err := fmt.Fprintf(tblfile, "%X=%s\n", table.codes[chars], chars); err != nil {
+			return err
+		}
+	}
+	if len(table.Newline) > 0 {
+		if _, err := fmt.Fprintf(tblfile, "*%X\n", table.Newline); err != nil {
+			return err
+		}
+	}
+	if len(table.End) > 0 {
+		if _, err := fmt.Fprintf(tblfile, "/%X\n", table.End); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package chr
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+//Encode encodes a text into bytes, taking at each position the longest characters mapped by the table.
+//The line breaks are encoded with the newline code of the table.
+func (table *CharTable) Encode(text string) ([]byte, error) {
+	maxLen := 0
+	for chars := range table.codes {
+		if len(chars) > maxLen {
+			maxLen = len(chars)
+		}
+	}
+
+	var encoded []byte
+	line, col := 1, 1
+	for i := 0; i < len(text); {
+		if text[i] == '\n' {
+			if len(table.Newline) == 0 {
+				return nil, fmt.Errorf("line break at line %d, but the table has no newline code", line)
+			}
+			encoded = append(encoded, table.Newline...)
+			i, line, col = i+1, line+1, 1
+			continue
+		}
+
+		n := maxLen
+		if n > len(text)-i {
+			n = len(text) - i
+		}
+		for ; n > 0; n-- {
+			if code, ok := table.codes[text[i:i+n]]; ok {
+				encoded = append(encoded, code...)
+				break
+			}
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("unmapped character %q at line %d, column %d", []rune(text[i:])[0], line, col)
+		}
+		i, col = i+n, col+len([]rune(text[i:i+n]))
+	}
+
+	return encoded, nil
+}
+
+//Texts are strings encoded with a character table, each one with a name
+type Texts struct {
+	names   []string
+	encoded [][]byte
+}
+
+//NewTexts encodes named strings with a character table, ending each one with the end code of the table, if any
+func NewTexts(names, texts []string, table *CharTable) (*Texts, error) {
+	encodedTexts := &Texts{names: names}
+	for i, text := range texts {
+		encoded, err := table.Encode(text)
+		if err != nil {
+			return nil, fmt.Errorf("string %s: %s", names[i], err.Error())
+		}
+		encodedTexts.encoded = append(encodedTexts.encoded, append(encoded, table.End...))
+	}
+
+	return encodedTexts, nil
+}
+
+//Size returns the amount of strings
+func (texts *Texts) Size() int {
+	return len(texts.encoded)
+}
+
+//At returns the name and the bytes of the string i
+func (texts *Texts) At(i int) (string, []byte) {
+	return texts.names[i], texts.encoded[i]
+}
+
+//WriteC write the strings to a .c and .h files, each one labeled with the suffix _<name>, followed by a table of pointers to them labeled by the file name
+func (texts *Texts) WriteC(filename string) error {
+	labels := texts.labels(filename)
+	if err := writeTablesC(filename, labels, texts.encoded); err != nil {
+		return err
+	}
+
+	label := labelName(filename)
+	cfile, err := os.OpenFile(ChangeFileExtension(filename, "c"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer cfile.Close()
+	fmt.Fprintf(cfile, "const char* const %s[] = {\n\t%s\n};\n", label, strings.Join(labels, ",\n\t"))
+
+	hfile, err := os.OpenFile(ChangeFileExtension(filename, "h"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer hfile.Close()
+	fmt.Fprintf(hfile, "extern const char* const %s[%d];\n", label, len(labels))
+
+	return nil
+}
+
+//WriteAsm write the strings to a .inc file, each one labeled with the suffix _<name>, followed by a table of pointers to them labeled by the file name
+func (texts *Texts) WriteAsm(filename string) error {
+	labels := texts.labels(filename)
+	if err := writeTablesAsm(filename, labels, texts.encoded); err != nil {
+		return err
+	}
+
+	asmfile, err := os.OpenFile(ChangeFileExtension(filename, "inc"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer asmfile.Close()
+	fmt.Fprintf(asmfile, "%s:\n", labelName(filename))
+	for _, label := range labels {
+		fmt.Fprintf(asmfile, "\t.word %s\n", label)
+	}
+
+	return nil
+}
+
+//WriteBin write the strings, one after the other, to a .bin file
+func (texts *Texts) WriteBin(filename string) error {
+	var bytes []byte
+	for _, encoded := range texts.encoded {
+		bytes = append(bytes, encoded...)
+	}
+	return writeBytesBin(filename, bytes)
+}
+
+func (texts *Texts) labels(filename string) []string {
+	var labels []string
+	for _, name := range texts.names {
+		labels = append(labels, labelName(filename)+"_"+name)
+	}
+	return labels
+}
@@ -1,6 +1,8 @@
 package chr
 
 import (
+	"image"
+	"image/color"
 	"math/bits"
 )
 
@@ -19,6 +21,22 @@ const (
 	Tile8x16 TileDimension = "8x16"
 )
 
+//Height returns the height in pixels of a tile
+func (dim TileDimension) Height() int {
+	if dim == Tile8x16 {
+		return 16
+	}
+	return 8
+}
+
+//TilePalette is the palette used to draw a tile, from black (color 0) to white (color 3)
+var TilePalette = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xff},
+	color.RGBA{0x55, 0x55, 0x55, 0xff},
+	color.RGBA{0xaa, 0xaa, 0xaa, 0xff},
+	color.RGBA{0xff, 0xff, 0xff, 0xff},
+}
+
 //NewTile constructs a new Tile
 func NewTile(bytes []byte) Tile {
 	tile := Tile{}
@@ -28,6 +46,140 @@ func NewTile(bytes []byte) Tile {
 	return tile
 }
 
+//NewTileFromPixels constructs a new Tile from the colors [0,3] of its pixels, indexed by [y][x]
+func NewTileFromPixels(pixels [8][8]byte) Tile {
+	tile := Tile{}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			tile.SetPixel(x, y, pixels[y][x])
+		}
+	}
+
+	return tile
+}
+
+//Pixel returns the color [0,3] of the pixel at (x,y)
+func (tile *Tile) Pixel(x, y int) byte {
+	shift := uint(7 - x)
+	return (tile.Plane[0][y]>>shift)&1 | ((tile.Plane[1][y]>>shift)&1)<<1
+}
+
+//SetPixel changes the color [0,3] of the pixel at (x,y)
+func (tile *Tile) SetPixel(x, y int, color byte) {
+	shift := uint(7 - x)
+	tile.Plane[0][y] = tile.Plane[0][y]&^(1<<shift) | (color&1)<<shift
+	tile.Plane[1][y] = tile.Plane[1][y]&^(1<<shift) | ((color&2)>>1)<<shift
+}
+
+//Pixels returns the colors of all pixels, indexed by [y][x]
+func (tile *Tile) Pixels() [8][8]byte {
+	var pixels [8][8]byte
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			pixels[y][x] = tile.Pixel(x, y)
+		}
+	}
+
+	return pixels
+}
+
+//Mirror returns a new tile that is the horizontal mirror of this tile
+func (tile *Tile) Mirror() *Tile {
+	return tile.transform(func(x, y int) (int, int) { return 7 - x, y })
+}
+
+//Flip returns a new tile that is the vertical mirror of this tile
+func (tile *Tile) Flip() *Tile {
+	return tile.transform(func(x, y int) (int, int) { return x, 7 - y })
+}
+
+//MirrorFlip returns a new tile that is the horizontal and vertical mirror of this tile
+func (tile *Tile) MirrorFlip() *Tile {
+	return tile.transform(func(x, y int) (int, int) { return 7 - x, 7 - y })
+}
+
+//Rotate90 returns a new tile that is this tile rotated 90 degrees clockwise
+func (tile *Tile) Rotate90() *Tile {
+	return tile.transform(func(x, y int) (int, int) { return y, 7 - x })
+}
+
+//Rotate180 returns a new tile that is this tile rotated 180 degrees
+func (tile *Tile) Rotate180() *Tile {
+	return tile.MirrorFlip()
+}
+
+//Rotate270 returns a new tile that is this tile rotated 270 degrees clockwise
+func (tile *Tile) Rotate270() *Tile {
+	return tile.transform(func(x, y int) (int, int) { return 7 - y, x })
+}
+
+//Shift returns a new tile with the pixels of this tile moved by (dx,dy).
+//The pixels moved out of the tile come back on the opposite side if wrap is true, otherwise the uncovered pixels get the fill color.
+func (tile *Tile) Shift(dx, dy int, wrap bool, fill byte) *Tile {
+	shifted := new(Tile)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			srcX, srcY := x-dx, y-dy
+			if wrap {
+				srcX, srcY = (srcX%8+8)%8, (srcY%8+8)%8
+			} else if srcX < 0 || srcX > 7 || srcY < 0 || srcY > 7 {
+				shifted.SetPixel(x, y, fill)
+				continue
+			}
+			shifted.SetPixel(x, y, tile.Pixel(srcX, srcY))
+		}
+	}
+
+	return shifted
+}
+
+//Permute returns a new tile with the colors of this tile changed by a color map
+func (tile *Tile) Permute(colormap ColorMap) *Tile {
+	permuted := new(Tile)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			permuted.SetPixel(x, y, colormap.Map(tile.Pixel(x, y)))
+		}
+	}
+
+	return permuted
+}
+
+//transform returns a new tile where each pixel (x,y) comes from the pixel of this tile at src(x,y)
+func (tile *Tile) transform(src func(x, y int) (int, int)) *Tile {
+	transformed := new(Tile)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			transformed.SetPixel(x, y, tile.Pixel(src(x, y)))
+		}
+	}
+
+	return transformed
+}
+
+//ColorModel implements image.Image, the tile colors are drawn with the TilePalette
+func (tile *Tile) ColorModel() color.Model {
+	return TilePalette
+}
+
+//Bounds implements image.Image
+func (tile *Tile) Bounds() image.Rectangle {
+	return image.Rect(0, 0, 8, 8)
+}
+
+//At implements image.Image
+func (tile *Tile) At(x, y int) color.Color {
+	return TilePalette[tile.ColorIndexAt(x, y)]
+}
+
+//ColorIndexAt implements image.PalettedImage
+func (tile *Tile) ColorIndexAt(x, y int) uint8 {
+	if !(image.Point{x, y}).In(tile.Bounds()) {
+		return 0
+	}
+	return tile.Pixel(x, y)
+}
+
 //Equals returns true if 2 tiles has the same planes
 func (tile *Tile) Equals(other *Tile) bool {
 	for p := 0; p < 2; p++ {
@@ -0,0 +1,399 @@
+package chr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Flags of the gids of a Tiled map, telling how the tile is flipped or rotated
+const (
+	tiledFlipH    = 0x80000000
+	tiledFlipV    = 0x40000000
+	tiledFlipD    = 0x20000000
+	tiledRotateH  = 0x10000000
+	tiledFlagMask = tiledFlipH | tiledFlipV | tiledFlipD | tiledRotateH
+)
+
+//Properties of the tiles of a Tiled tileset
+const (
+	//TiledPaletteProperty is the palette [0,3] a tile is drawn with
+	TiledPaletteProperty = "palette"
+	//TiledIndexProperty is the index of a tile in the CHR
+	TiledIndexProperty = "index"
+	//TiledBankProperty is the CHR bank of a tile
+	TiledBankProperty = "bank"
+)
+
+//TiledMap is a map (.tmx) of the Tiled map editor
+type TiledMap struct {
+	XMLName     xml.Name       `xml:"map"`
+	Orientation string         `xml:"orientation,attr"`
+	Width       int            `xml:"width,attr"`
+	Height      int            `xml:"height,attr"`
+	TileWidth   int            `xml:"tilewidth,attr"`
+	TileHeight  int            `xml:"tileheight,attr"`
+	Infinite    int            `xml:"infinite,attr"`
+	Tilesets    []TiledTileset `xml:"tileset"`
+	Layers      []TiledLayer   `xml:"layer"`
+}
+
+//TiledTileset is a tileset of the Tiled map editor, embedded in a map or in its own file (.tsx)
+type TiledTileset struct {
+	XMLName    xml.Name    `xml:"tileset"`
+	FirstGid   uint32      `xml:"firstgid,attr,omitempty"`
+	Source     string      `xml:"source,attr,omitempty"`
+	Version    string      `xml:"version,attr,omitempty"`
+	Name       string      `xml:"name,attr,omitempty"`
+	TileWidth  int         `xml:"tilewidth,attr,omitempty"`
+	TileHeight int         `xml:"tileheight,attr,omitempty"`
+	Spacing    int         `xml:"spacing,attr,omitempty"`
+	Margin     int         `xml:"margin,attr,omitempty"`
+	TileCount  int         `xml:"tilecount,attr,omitempty"`
+	Columns    int         `xml:"columns,attr,omitempty"`
+	Image      *TiledImage `xml:"image"`
+	Tiles      []TiledTile `xml:"tile"`
+}
+
+//TiledImage is the image of a Tiled tileset
+type TiledImage struct {
+	Source string `xml:"source,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+}
+
+//TiledTile has the properties of a tile of a Tiled tileset
+type TiledTile struct {
+	ID         int             `xml:"id,attr"`
+	Properties []TiledProperty `xml:"properties>property"`
+}
+
+//TiledProperty is a custom property of the Tiled map editor
+type TiledProperty struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:"value,attr"`
+}
+
+//TiledLayer is a tile layer of a Tiled map
+type TiledLayer struct {
+	Name   string    `xml:"name,attr"`
+	Width  int       `xml:"width,attr"`
+	Height int       `xml:"height,attr"`
+	Data   TiledData `xml:"data"`
+}
+
+//TiledData are the gids of a tile layer, as XML elements, CSV or base64 optionally compressed
+type TiledData struct {
+	Encoding    string `xml:"encoding,attr"`
+	Compression string `xml:"compression,attr"`
+	Content     string `xml:",chardata"`
+	Tiles       []struct {
+		Gid uint32 `xml:"gid,attr"`
+	} `xml:"tile"`
+}
+
+//ReadTiledMap reads a Tiled map with its tilesets, resolving the paths of the images from the directory of the file declaring them
+func ReadTiledMap(filename string) (*TiledMap, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	tmap := new(TiledMap)
+	if err := xml.Unmarshal(content, tmap); err != nil {
+		return nil, fmt.Errorf("invalid Tiled map %s: %s", filename, err.Error())
+	}
+
+	switch {
+	case tmap.Orientation != "orthogonal":
+		return nil, fmt.Errorf("Tiled map %s must be orthogonal, not %s", filename, tmap.Orientation)
+	case tmap.Infinite != 0:
+		return nil, fmt.Errorf("Tiled map %s must not be infinite", filename)
+	case tmap.TileWidth != 8 || tmap.TileHeight != 8:
+		return nil, fmt.Errorf("Tiled map %s must have tiles of 8x8 pixels, not %dx%d", filename, tmap.TileWidth, tmap.TileHeight)
+	}
+
+	dir := filepath.Dir(filename)
+	for i, tileset := range tmap.Tilesets {
+		if len(tileset.Source) > 0 {
+			external, err := ReadTiledTileset(joinPath(dir, tileset.Source))
+			if err != nil {
+				return nil, err
+			}
+			external.FirstGid = tileset.FirstGid
+			external.Source = joinPath(dir, tileset.Source)
+			tmap.Tilesets[i] = *external
+		} else if tileset.Image != nil {
+			tmap.Tilesets[i].Image.Source = joinPath(dir, tileset.Image.Source)
+		}
+	}
+
+	return tmap, nil
+}
+
+//ReadTiledTileset reads a Tiled tileset, resolving the path of its image from the directory of the file
+func ReadTiledTileset(filename string) (*TiledTileset, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	tileset := new(TiledTileset)
+	if err := xml.Unmarshal(content, tileset); err != nil {
+		return nil, fmt.Errorf("invalid Tiled tileset %s: %s", filename, err.Error())
+	}
+	if tileset.Image != nil {
+		tileset.Image.Source = joinPath(filepath.Dir(filename), tileset.Image.Source)
+	}
+
+	return tileset, nil
+}
+
+//Gids returns the gids of the layer row by row, with the flags telling how each tile is flipped or rotated
+func (layer *TiledLayer) Gids() ([]uint32, error) {
+	var gids []uint32
+
+	switch layer.Data.Encoding {
+	case "":
+		for _, tile := range layer.Data.Tiles {
+			gids = append(gids, tile.Gid)
+		}
+	case "csv":
+		for _, field := range strings.Split(layer.Data.Content, ",") {
+			gid, err := strconv.ParseUint(strings.TrimSpace(field), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("layer %s has an invalid gid '%s'", layer.Name, strings.TrimSpace(field))
+			}
+			gids = append(gids, uint32(gid))
+		}
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(layer.Data.Content))
+		if err != nil {
+			return nil, fmt.Errorf("layer %s has invalid base64 data: %s", layer.Name, err.Error())
+		}
+		if data, err = decompressTiled(data, layer.Data.Compression); err != nil {
+			return nil, fmt.Errorf("layer %s has invalid %s data: %s", layer.Name, layer.Data.Compression, err.Error())
+		}
+		for i := 0; i+4 <= len(data); i += 4 {
+			gids = append(gids, binary.LittleEndian.Uint32(data[i:]))
+		}
+	default:
+		return nil, fmt.Errorf("layer %s has the unknown encoding %s", layer.Name, layer.Data.Encoding)
+	}
+
+	if len(gids) != layer.Width*layer.Height {
+		return nil, fmt.Errorf("layer %s has %d tiles but must have %dx%d", layer.Name, len(gids), layer.Width, layer.Height)
+	}
+
+	return gids, nil
+}
+
+func decompressTiled(data []byte, compression string) ([]byte, error) {
+	switch compression {
+	case "":
+		return data, nil
+	case "zlib":
+		reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(reader)
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(reader)
+	}
+	return nil, fmt.Errorf("unsupported compression")
+}
+
+//NewTilesetFromTiled converts the images of the tilesets of a Tiled map, given in the same order, into a tileset without duplicated tiles whose 1st tile is empty.
+//It also returns the cell of each gid: its tile in the tileset and the palette given by the tile property TiledPaletteProperty.
+func NewTilesetFromTiled(tmap *TiledMap, images []image.PalettedImage, colormap ColorMap) (*Tileset, map[uint32]NametableCell, error) {
+	tileset := NewTileset(Tile8x8)
+	tileset.tiles = append(tileset.tiles, &Tile{})
+	cells := make(map[uint32]NametableCell)
+
+	for i, tiledTileset := range tmap.Tilesets {
+		if tiledTileset.TileWidth != 8 || tiledTileset.TileHeight != 8 {
+			return nil, nil, fmt.Errorf("tileset %s must have tiles of 8x8 pixels, not %dx%d", tiledTileset.Name, tiledTileset.TileWidth, tiledTileset.TileHeight)
+		}
+
+		palettes, err := tiledPalettes(&tiledTileset)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		img := images[i]
+		bounds := img.Bounds()
+		cols, rows := tiledTileset.Columns, (bounds.Dy()-2*tiledTileset.Margin+tiledTileset.Spacing)/(8+tiledTileset.Spacing)
+		if cols == 0 {
+			cols = (bounds.Dx() - 2*tiledTileset.Margin + tiledTileset.Spacing) / (8 + tiledTileset.Spacing)
+		}
+		if cols <= 0 || rows <= 0 {
+			return nil, nil, fmt.Errorf("tileset %s has no tile of 8x8 pixels in its image of %dx%d pixels", tiledTileset.Name, bounds.Dx(), bounds.Dy())
+		}
+		count := tiledTileset.TileCount
+		if count == 0 {
+			count = cols * rows
+		}
+
+		for id := 0; id < count; id++ {
+			x := bounds.Min.X + tiledTileset.Margin + (id%cols)*(8+tiledTileset.Spacing)
+			y := bounds.Min.Y + tiledTileset.Margin + (id/cols)*(8+tiledTileset.Spacing)
+			if !image.Rect(x, y, x+8, y+8).In(bounds) {
+				return nil, nil, fmt.Errorf("tile %d of the tileset %s is out of its image", id, tiledTileset.Name)
+			}
+
+			var pixels [8][8]byte
+			for py := 0; py < 8; py++ {
+				for px := 0; px < 8; px++ {
+					pixels[py][px] = colormap.Map(img.ColorIndexAt(x+px, y+py))
+				}
+			}
+			tile := NewTileFromPixels(pixels)
+
+			idx := tileset.indexOf(&tile)
+			if idx < 0 {
+				if tileset.Size() == TilesetMaxRows*TilesetMaxCols {
+					return nil, nil, fmt.Errorf("the tilesets have more than %d different tiles", TilesetMaxRows*TilesetMaxCols)
+				}
+				idx = tileset.Size()
+				tileset.tiles = append(tileset.tiles, &tile)
+			}
+
+			cells[tiledTileset.FirstGid+uint32(id)] = NametableCell{Idx: byte(idx), Pal: palettes[id]}
+		}
+	}
+
+	return tileset, cells, nil
+}
+
+//tiledPalettes returns the palettes of the tiles of a Tiled tileset, by tile id
+func tiledPalettes(tiledTileset *TiledTileset) (map[int]byte, error) {
+	palettes := make(map[int]byte)
+	for _, tile := range tiledTileset.Tiles {
+		for _, property := range tile.Properties {
+			if property.Name != TiledPaletteProperty {
+				continue
+			}
+			pal, err := strconv.ParseUint(property.Value, 10, 8)
+			if err != nil || pal > 3 {
+				return nil, fmt.Errorf("tile %d of the tileset %s has an invalid palette '%s'", tile.ID, tiledTileset.Name, property.Value)
+			}
+			palettes[tile.ID] = byte(pal)
+		}
+	}
+	return palettes, nil
+}
+
+//NewNametableFromTiled converts a tile layer into a nametable, given the cell of each gid.
+//The empty tiles of the layer become the tile 0 and the flipped or rotated tiles are rejected, as the background tiles cannot be flipped.
+func NewNametableFromTiled(layer *TiledLayer, cells map[uint32]NametableCell) (*Nametable, error) {
+	gids, err := layer.Gids()
+	if err != nil {
+		return nil, err
+	}
+
+	nametable := NewNametable(layer.Width, layer.Height)
+	for i, gid := range gids {
+		x, y := i%layer.Width, i/layer.Width
+		if gid&tiledFlagMask != 0 {
+			return nil, fmt.Errorf("layer %s: tile (%d,%d) is flipped or rotated, but background tiles cannot be", layer.Name, x, y)
+		}
+		if gid == 0 {
+			continue
+		}
+
+		cell, ok := cells[gid]
+		if !ok {
+			return nil, fmt.Errorf("layer %s: tile (%d,%d) has the gid %d that belongs to no tileset", layer.Name, x, y, gid)
+		}
+		nametable.Set(x, y, cell)
+	}
+
+	return nametable, nil
+}
+
+//WriteTiled write the tileset to a .png image, 16 tiles per row, and to a .tsx Tiled tileset of the image.
+//The image is indexed by the colors of the tiles, drawn with a palette of 4 colors, so the tileset converts back into the same tiles.
+//Each tile has the properties TiledIndexProperty, its index in the tileset, TiledBankProperty, its bank of bankTiles tiles, and TiledPaletteProperty, pal.
+func (tileset *Tileset) WriteTiled(filename string, palette color.Palette, pal byte, bankTiles int) error {
+	rows := (tileset.Size() + TilesetMaxCols - 1) / TilesetMaxCols
+	img := image.NewPaletted(image.Rect(0, 0, TilesetMaxCols*8, rows*8), palette)
+	for i, tile := range tileset.tiles {
+		x, y := (i%TilesetMaxCols)*8, (i/TilesetMaxCols)*8
+		for py := 0; py < 8; py++ {
+			for px := 0; px < 8; px++ {
+				img.SetColorIndex(x+px, y+py, tile.Pixel(px, py))
+			}
+		}
+	}
+
+	pngfilename := ChangeFileExtension(filename, "png")
+	pngfile, err := os.OpenFile(pngfilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer pngfile.Close()
+
+	if err := png.Encode(pngfile, img); err != nil {
+		return err
+	}
+
+	tiled := TiledTileset{
+		Version:    "1.10",
+		Name:       labelName(filename),
+		TileWidth:  8,
+		TileHeight: 8,
+		TileCount:  tileset.Size(),
+		Columns:    TilesetMaxCols,
+		Image:      &TiledImage{Source: filepath.Base(pngfilename), Width: img.Rect.Dx(), Height: img.Rect.Dy()},
+	}
+	for i := range tileset.tiles {
+		tiled.Tiles = append(tiled.Tiles, TiledTile{ID: i, Properties: []TiledProperty{
+			{Name: TiledIndexProperty, Type: "int", Value: strconv.Itoa(i)},
+			{Name: TiledBankProperty, Type: "int", Value: strconv.Itoa(i / bankTiles)},
+			{Name: TiledPaletteProperty, Type: "int", Value: strconv.Itoa(int(pal))},
+		}})
+	}
+
+	content, err := xml.MarshalIndent(tiled, "", " ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(ChangeFileExtension(filename, "tsx"), append([]byte(xml.Header), append(content, '\n')...), 0600)
+}
+
+//indexOf returns the position of the 1st tile equal to a tile, or -1 if none
+func (tileset *Tileset) indexOf(tile *Tile) int {
+	for i, other := range tileset.tiles {
+		if other.Equals(tile) {
+			return i
+		}
+	}
+	return -1
+}
+
+func joinPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
@@ -1,7 +1,9 @@
 package chr
 
 import (
+	"fmt"
 	"image"
+	"io"
 	"os"
 )
 
@@ -18,8 +20,8 @@ type Tileset struct {
 	tiledim TileDimension
 }
 
-//NewTilesetFromPNG builds a tileset from an indexed PNG image
-func NewTilesetFromPNG(img image.PalettedImage, bgColorIdx byte) *Tileset {
+//NewTilesetFromPNG builds a tileset from an indexed PNG image, mapping its color indexes through colormap
+func NewTilesetFromPNG(img image.PalettedImage, colormap ColorMap) *Tileset {
 	tileset := new(Tileset)
 	tileset.tiledim = Tile8x8
 	for i := 0; i < 256; i++ {
@@ -36,14 +38,7 @@ func NewTilesetFromPNG(img image.PalettedImage, bgColorIdx byte) *Tileset {
 			//http://wiki.nesdev.com/w/index.php/PPU_pattern_tables
 			for i := byte(0); i < 8; i++ {
 				for j := byte(0); j < 8; j++ {
-					pixel := pixels[i*8+j]
-					if bgColorIdx > 0 {
-						if pixel == byte(bgColorIdx) {
-							pixel = 0
-						} else if pixel == 0 {
-							pixel = byte(bgColorIdx)
-						}
-					}
+					pixel := colormap.Map(pixels[i*8+j])
 
 					tile.Plane[0][i] |= (pixel & 1) << (7 - j)
 					tile.Plane[1][i] |= ((pixel & 2) >> 1) << (7 - j)
@@ -55,6 +50,24 @@ func NewTilesetFromPNG(img image.PalettedImage, bgColorIdx byte) *Tileset {
 	return tileset
 }
 
+//NewTileMaskFromPNG tells, for each tile of the tileset built by NewTilesetFromPNG from an image of same dimension, if any of its pixels matches
+func NewTileMaskFromPNG(img image.PalettedImage, match func(idx byte) bool) []bool {
+	mask := make([]bool, TilesetMaxRows*TilesetMaxCols)
+	bounds := img.Bounds()
+	h := bounds.Dy()
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if match(img.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y)) {
+				row := TilesetMaxRows - h/8 + y/8
+				mask[row*TilesetMaxCols+x/8] = true
+			}
+		}
+	}
+
+	return mask
+}
+
 //NewTilesetFromCHR builds a tileset from a CHR file
 func NewTilesetFromCHR(chrfile *os.File, dim TileDimension) (*Tileset, error) {
 	stat, err := chrfile.Stat()
@@ -63,9 +76,12 @@ func NewTilesetFromCHR(chrfile *os.File, dim TileDimension) (*Tileset, error) {
 	}
 
 	bytes := make([]byte, stat.Size())
-	if _, err := chrfile.Read(bytes); err != nil {
+	if _, err := io.ReadFull(chrfile, bytes); err != nil {
 		return nil, err
 	}
+	if len(bytes)%16 != 0 {
+		return nil, fmt.Errorf("%s has %d bytes, which is not a multiple of a tile size (16 bytes)", chrfile.Name(), len(bytes))
+	}
 
 	tileset := NewTileset(dim)
 	for i := 0; i < len(bytes); i += 16 {
@@ -105,11 +121,12 @@ func (tileset *Tileset) To8x16() {
 }
 
 func (tileset Tileset) Write(filename string) error {
-	chrfile := changeFileExtension(filename, "chr")
+	chrfile := ChangeFileExtension(filename, "chr")
 	file, err := os.OpenFile(chrfile, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	for _, tile := range tileset.tiles {
 		if _, err := file.Write(tile.Plane[0][:]); err != nil {
@@ -128,6 +145,17 @@ func (tileset *Tileset) At(i int) *Tile {
 	return tileset.tiles[i]
 }
 
+//Set replaces the tile at position i by a copy of a tile
+func (tileset *Tileset) Set(i int, tile *Tile) {
+	copied := *tile
+	tileset.tiles[i] = &copied
+}
+
+//Swap exchanges the tiles at positions i and j
+func (tileset *Tileset) Swap(i, j int) {
+	tileset.tiles[i], tileset.tiles[j] = tileset.tiles[j], tileset.tiles[i]
+}
+
 //RemoveAt remove an tile at position i
 func (tileset *Tileset) RemoveAt(i int) {
 	tileset.tiles = append(tileset.tiles[:i], tileset.tiles[i+1:]...)
@@ -0,0 +1,239 @@
+package chr
+
+import (
+	"fmt"
+	"image"
+)
+
+//VRAM update list formats
+const (
+	VramFormatNeslib = "neslib"
+	VramFormatRaw    = "raw"
+)
+
+//Flags of the address MSB of a VRAM update of neslib
+const (
+	vramUpdHorz = 0x40
+	vramUpdVert = 0x80
+	vramUpdEOF  = 0xff
+)
+
+//Addresses of the PPU
+const (
+	//NametableAddr is the address of the 1st nametable
+	NametableAddr = 0x2000
+	//attributeOffset is the offset of the attribute table from the address of its nametable
+	attributeOffset = 0x3c0
+)
+
+//vramUpdateMaxLen is the maximum amount of bytes of an entry of a VRAM update list
+const vramUpdateMaxLen = 255
+
+//NeslibMaxListSize is the maximum size of a list in the neslib format, including the ending 0xff, as flush_vram_update reads it with an 8 bits index
+const NeslibMaxListSize = 256
+
+//VramUpdate writes bytes into the VRAM from an address, incrementing it by 1 or, when vertical, by 32
+type VramUpdate struct {
+	Addr     uint16
+	Vertical bool
+	Data     []byte
+}
+
+//NewTileUpdate returns the update writing tiles of a tileset into the pattern table at an address, e.g. to load tiles into CHR-RAM
+func NewTileUpdate(tileset *Tileset, first, count int, addr uint16) (VramUpdate, error) {
+	if first < 0 || count < 1 || first+count > tileset.Size() {
+		return VramUpdate{}, fmt.Errorf("tiles [%d,%d] are out of the %d tiles of the tileset", first, first+count-1, tileset.Size())
+	}
+	if int(addr)+count*16 > NametableAddr {
+		return VramUpdate{}, fmt.Errorf("%d tiles from the address 0x%04x exceed the pattern tables", count, addr)
+	}
+
+	update := VramUpdate{Addr: addr}
+	for i := first; i < first+count; i++ {
+		tile := tileset.At(i)
+		update.Data = append(update.Data, tile.Plane[0][:]...)
+		update.Data = append(update.Data, tile.Plane[1][:]...)
+	}
+
+	return update, nil
+}
+
+//NewNametableUpdates returns the updates writing a region of a nametable of 32x30 tiles, with its attributes, into the nametable at an address, e.g. NametableAddr.
+//The tiles are written row by row or, when vertical, column by column. The attributes are written row by row.
+func NewNametableUpdates(nametable *Nametable, region image.Rectangle, addr uint16, vertical bool) ([]VramUpdate, error) {
+	if nametable.width != 32 || nametable.height != 30 {
+		return nil, fmt.Errorf("nametable must have 32x30 tiles, not %dx%d", nametable.width, nametable.height)
+	}
+	if region.Empty() || !region.In(image.Rect(0, 0, nametable.width, nametable.height)) {
+		return nil, fmt.Errorf("region %v is out of the nametable of %dx%d tiles", region, nametable.width, nametable.height)
+	}
+	if addr&0x3ff != 0 || addr < NametableAddr || addr >= NametableAddr+0x1000 {
+		return nil, fmt.Errorf("address 0x%04x is not the address of a nametable", addr)
+	}
+
+	var updates []VramUpdate
+	if vertical {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			update := VramUpdate{Addr: addr + uint16(region.Min.Y*32+x), Vertical: true}
+			for y := region.Min.Y; y < region.Max.Y; y++ {
+				update.Data = append(update.Data, nametable.At(x, y).Idx)
+			}
+			updates = append(updates, update)
+		}
+	} else {
+		for y := region.Min.Y; y < region.Max.Y; y++ {
+			update := VramUpdate{Addr: addr + uint16(y*32+region.Min.X)}
+			for x := region.Min.X; x < region.Max.X; x++ {
+				update.Data = append(update.Data, nametable.At(x, y).Idx)
+			}
+			updates = append(updates, update)
+		}
+	}
+
+	attrs, err := nametable.Attributes()
+	if err != nil {
+		return nil, err
+	}
+	for ay := region.Min.Y / 4; ay <= (region.Max.Y-1)/4; ay++ {
+		first, last := region.Min.X/4, (region.Max.X-1)/4
+		updates = append(updates, VramUpdate{
+			Addr: addr + attributeOffset + uint16(ay*8+first),
+			Data: attrs[ay*8+first : ay*8+last+1],
+		})
+	}
+
+	return updates, nil
+}
+
+//NewNametableFromBytes builds a nametable from the tiles row by row followed by the attribute table, as written by Nametable.WriteBin
+func NewNametableFromBytes(bytes []byte, width, height int) (*Nametable, error) {
+	nametable := NewNametable(width, height)
+	cols, rows := (width+3)/4, (height+3)/4
+	if len(bytes) != width*height+cols*rows {
+		return nil, fmt.Errorf("nametable of %dx%d tiles must have %d bytes, not %d", width, height, width*height+cols*rows, len(bytes))
+	}
+
+	attrs := bytes[width*height:]
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			shift := uint(((y/2)%2)*4 + ((x/2)%2)*2)
+			nametable.Set(x, y, NametableCell{
+				Idx: bytes[y*width+x],
+				Pal: (attrs[(y/4)*cols+x/4] >> shift) & 3,
+			})
+		}
+	}
+
+	return nametable, nil
+}
+
+//VramUpdateLists are the updates encoded into lists, one per frame
+type VramUpdateLists struct {
+	lists [][]byte
+}
+
+//NewVramUpdateLists encodes the updates into lists in a format, each one ended by 0xff and with at most budget bytes, 0 being no limit.
+//In the neslib format the lists never exceed NeslibMaxListSize bytes, whatever the budget.
+//The updates that do not fit in the budget of a frame are split, continuing at the next frame.
+//
+//In the neslib format, the format of set_vram_update, a single byte is written as MSB, LSB, byte and many bytes as MSB|0x40, LSB, LEN, bytes
+//or, when vertical, as MSB|0x80, LSB, LEN, bytes.
+//In the raw format, all updates are written as MSB, LSB, LEN, bytes, with the bit 7 of MSB set when vertical.
+func NewVramUpdateLists(updates []VramUpdate, format string, budget int) (*VramUpdateLists, error) {
+	if format != VramFormatNeslib && format != VramFormatRaw {
+		return nil, fmt.Errorf("unknown VRAM update format '%s'", format)
+	}
+	if budget != 0 && budget < 5 {
+		return nil, fmt.Errorf("budget of %d bytes per frame is less than the 5 bytes of the smallest list", budget)
+	}
+	if format == VramFormatNeslib && (budget == 0 || budget > NeslibMaxListSize) {
+		budget = NeslibMaxListSize
+	}
+
+	lists := &VramUpdateLists{}
+	var list []byte
+	for _, update := range updates {
+		addr, data := update.Addr, update.Data
+		for len(data) > 0 {
+			n := len(data)
+			if n > vramUpdateMaxLen {
+				n = vramUpdateMaxLen
+			}
+			if budget > 0 {
+				avail := budget - len(list) - 3 - 1
+				if avail < 1 {
+					lists.lists = append(lists.lists, append(list, vramUpdEOF))
+					list = nil
+					continue
+				}
+				if n > avail {
+					n = avail
+				}
+			}
+
+			list = appendVramUpdate(list, format, addr, update.Vertical, data[:n])
+			data = data[n:]
+			if update.Vertical {
+				addr += uint16(n) * 32
+			} else {
+				addr += uint16(n)
+			}
+		}
+	}
+	lists.lists = append(lists.lists, append(list, vramUpdEOF))
+
+	return lists, nil
+}
+
+func appendVramUpdate(list []byte, format string, addr uint16, vertical bool, data []byte) []byte {
+	msb, lsb := byte(addr>>8), byte(addr)
+	if format == VramFormatNeslib && len(data) == 1 {
+		return append(list, msb, lsb, data[0])
+	}
+
+	switch {
+	case vertical:
+		msb |= vramUpdVert
+	case format == VramFormatNeslib:
+		msb |= vramUpdHorz
+	}
+	list = append(list, msb, lsb, byte(len(data)))
+	return append(list, data...)
+}
+
+//Size returns the amount of lists, one per frame
+func (lists *VramUpdateLists) Size() int {
+	return len(lists.lists)
+}
+
+//At returns the list of the frame i
+func (lists *VramUpdateLists) At(i int) []byte {
+	return lists.lists[i]
+}
+
+//WriteC write the lists to a .c and .h files, each one labeled with the suffix _<frame>
+func (lists *VramUpdateLists) WriteC(filename string) error {
+	return writeTablesC(filename, lists.labels(filename), lists.lists)
+}
+
+//WriteAsm write the lists to a .inc file, each one labeled with the suffix _<frame>
+func (lists *VramUpdateLists) WriteAsm(filename string) error {
+	return writeTablesAsm(filename, lists.labels(filename), lists.lists)
+}
+
+//WriteBin write the lists, one after the other, to a .bin file
+func (lists *VramUpdateLists) WriteBin(filename string) error {
+	var bytes []byte
+	for _, list := range lists.lists {
+		bytes = append(bytes, list...)
+	}
+	return writeBytesBin(filename, bytes)
+}
+
+func (lists *VramUpdateLists) labels(filename string) []string {
+	var labels []string
+	for i := range lists.lists {
+		labels = append(labels, fmt.Sprintf("%s_%d", labelName(filename), i))
+	}
+	return labels
+}
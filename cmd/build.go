@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//DefaultManifest is the manifest file name used when none is given
+const DefaultManifest = "yanct.yaml"
+
+var buildCmd = &cobra.Command{
+	Use:   "build [MANIFEST]",
+	Short: "Build all assets declared in a manifest file",
+	Long: `Build all assets declared in a manifest file (default yanct.yaml).
+First all sprites are converted like img2spr, then the CHR groups are concatenated like concat and finally the metasprite groups are merged like mergemeta.
+The options of each asset have the same names and defaults of the respective command flags.
+All paths are relative to the manifest directory and the output directories are created when missing.
+A failed asset is reported and does not stop the build of the other assets.`,
+	Example: `Build the assets declared in the file 'yanct.yaml' of the current directory:
+
+yanct build
+
+Where 'yanct.yaml' contains:
+
+sprites:
+  - input: gfx/hero.png
+    output: build/hero
+    tile-height: 16
+    pal: 1
+    dx: -8
+    metasprite-format: [bin, c]
+  - input: gfx/enemy.png
+    output: build/enemy
+    tile-height: 16
+    color-map: 0,2,1,3,3
+chr:
+  - output: build/sprites.chr
+    tile-height: 16
+    inputs: [build/hero.chr, build/enemy.chr]
+metasprites:
+  - output: build/actors.bin
+    inputs: [build/hero.bin, build/enemy.bin]`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("build requires 1 manifest file at most")
+		}
+		return nil
+	},
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename := DefaultManifest
+		if len(args) > 0 {
+			filename = args[0]
+		}
+
+		return build(filename)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(buildCmd)
+}
+
+type manifest struct {
+	Sprites     []spriteAsset     `yaml:"sprites"`
+	CHRs        []chrAsset        `yaml:"chr"`
+	Metasprites []metaspriteAsset `yaml:"metasprites"`
+}
+
+type asset interface {
+	name() string
+	build(dir string) error
+}
+
+type spriteAsset struct {
+	Name        string     `yaml:"name"`
+	Input       string     `yaml:"input"`
+	Output      string     `yaml:"output"`
+	Pal         uint8      `yaml:"pal"`
+	BgColor     uint8      `yaml:"bg-color"`
+	ColorMap    string     `yaml:"color-map"`
+	TileH       uint8      `yaml:"tile-height"`
+	Dx          int8       `yaml:"dx"`
+	Dy          int8       `yaml:"dy"`
+	Formats     formatList `yaml:"metasprite-format"`
+	DelMirror   bool       `yaml:"del-mirror"`
+	DelFlip     bool       `yaml:"del-flip"`
+	Mirrored    bool       `yaml:"mirrored"`
+	Flipped     bool       `yaml:"flipped"`
+	PivotX      int8       `yaml:"pivot-x"`
+	PivotY      int8       `yaml:"pivot-y"`
+	BBox        bool       `yaml:"bbox"`
+	HitboxImg   string     `yaml:"hitbox-image"`
+	HitboxColor uint8      `yaml:"hitbox-color"`
+	PriorityImg string     `yaml:"priority-image"`
+	PriorityClr string     `yaml:"priority-color"`
+	BehindBg    bool       `yaml:"behind-bg"`
+	Dither      string     `yaml:"dither"`
+	SubPalette  string     `yaml:"sub-palette"`
+	PaletteFile string     `yaml:"palette-file"`
+}
+
+type chrAsset struct {
+	Name      string   `yaml:"name"`
+	Inputs    []string `yaml:"inputs"`
+	Output    string   `yaml:"output"`
+	TileH     uint8    `yaml:"tile-height"`
+	DelMirror bool     `yaml:"del-mirror"`
+	DelFlip   bool     `yaml:"del-flip"`
+}
+
+type metaspriteAsset struct {
+	Name   string   `yaml:"name"`
+	Inputs []string `yaml:"inputs"`
+	Output string   `yaml:"output"`
+}
+
+//formatList accepts a single metasprite format or a list of them
+type formatList []string
+
+func build(filename string) error {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var mf manifest
+	if err := yaml.UnmarshalStrict(bytes, &mf); err != nil {
+		return fmt.Errorf("Invalid manifest %s: %s", filename, err.Error())
+	}
+
+	var assets []asset
+	for i := range mf.Sprites {
+		assets = append(assets, &mf.Sprites[i])
+	}
+	for i := range mf.CHRs {
+		assets = append(assets, &mf.CHRs[i])
+	}
+	for i := range mf.Metasprites {
+		assets = append(assets, &mf.Metasprites[i])
+	}
+
+	// each asset sets its own flags, so the flags of the command are restored after the build
+	saved := flg
+	defer func() { flg = saved }()
+
+	dir := filepath.Dir(filename)
+	failed := 0
+	for _, asset := range assets {
+		if err := asset.build(dir); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", asset.name(), err.Error())
+		} else {
+			fmt.Printf("ok   %s\n", asset.name())
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d assets failed", failed, len(assets))
+	}
+	return nil
+}
+
+func (list *formatList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var format string
+	if err := unmarshal(&format); err == nil {
+		*list = formatList{format}
+		return nil
+	}
+
+	var formats []string
+	if err := unmarshal(&formats); err != nil {
+		return err
+	}
+	*list = formats
+	return nil
+}
+
+func (asset *spriteAsset) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain spriteAsset
+	*asset = spriteAsset{
+		TileH:     8,
+		Formats:   formatList{MetaspriteOutputBin},
+		DelMirror: true,
+		DelFlip:   true,
+	}
+	return unmarshal((*plain)(asset))
+}
+
+func (asset *spriteAsset) name() string {
+	return firstNonEmpty(asset.Name, asset.Output, asset.Input)
+}
+
+func (asset *spriteAsset) build(dir string) error {
+	if len(asset.Input) == 0 {
+		return errors.New("Missing image file name (input)")
+	}
+
+	input := resolvePath(dir, asset.Input)
+	output := ""
+	if len(asset.Output) > 0 {
+		output = resolvePath(dir, asset.Output)
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return err
+		}
+	}
+
+	flg = flag{
+		pal:         asset.Pal,
+		bgColor:     asset.BgColor,
+		colorMap:    asset.ColorMap,
+		tileH:       asset.TileH,
+		metasprFmts: asset.Formats,
+		fileOut:     output,
+		dx:          asset.Dx,
+		dy:          asset.Dy,
+		delMirror:   asset.DelMirror,
+		delFlip:     asset.DelFlip,
+		mirrored:    asset.Mirrored,
+		flipped:     asset.Flipped,
+		pivotX:      asset.PivotX,
+		pivotY:      asset.PivotY,
+		bbox:        asset.BBox,
+		hitboxColor: asset.HitboxColor,
+		priorityClr: asset.PriorityClr,
+		behindBg:    asset.BehindBg,
+		dither:      asset.Dither,
+		subPalette:  asset.SubPalette,
+	}
+	if len(asset.HitboxImg) > 0 {
+		flg.hitboxImg = resolvePath(dir, asset.HitboxImg)
+	}
+	if len(asset.PriorityImg) > 0 {
+		flg.priorityImg = resolvePath(dir, asset.PriorityImg)
+	}
+	if len(asset.PaletteFile) > 0 {
+		flg.paletteFile = resolvePath(dir, asset.PaletteFile)
+	}
+	if err := validateImg2spr(input); err != nil {
+		return err
+	}
+
+	return convert(input)
+}
+
+func (asset *chrAsset) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain chrAsset
+	*asset = chrAsset{
+		TileH:     8,
+		DelMirror: true,
+		DelFlip:   true,
+	}
+	return unmarshal((*plain)(asset))
+}
+
+func (asset *chrAsset) name() string {
+	return firstNonEmpty(asset.Name, asset.Output)
+}
+
+func (asset *chrAsset) build(dir string) error {
+	if len(asset.Inputs) == 0 {
+		return errors.New("Missing CHR file names (inputs)")
+	}
+
+	flg = flag{
+		tileH:      asset.TileH,
+		metasprFmt: MetaspriteOutputBin,
+		delMirror:  asset.DelMirror,
+		delFlip:    asset.DelFlip,
+	}
+	if len(asset.Output) > 0 {
+		flg.fileOut = resolvePath(dir, asset.Output)
+	}
+	if err := validateTileH(); err != nil {
+		return err
+	}
+	if err := validateOutFileName(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(flg.fileOut), 0755); err != nil {
+		return err
+	}
+
+	return concat(resolvePaths(dir, asset.Inputs)...)
+}
+
+func (asset *metaspriteAsset) name() string {
+	return firstNonEmpty(asset.Name, asset.Output)
+}
+
+func (asset *metaspriteAsset) build(dir string) error {
+	if len(asset.Inputs) == 0 {
+		return errors.New("Missing metasprite file names (inputs)")
+	}
+
+	flg = flag{}
+	if len(asset.Output) > 0 {
+		flg.fileOut = resolvePath(dir, asset.Output)
+	}
+	if err := validateOutFileName(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(flg.fileOut), 0755); err != nil {
+		return err
+	}
+
+	return mergemeta(resolvePaths(dir, asset.Inputs)...)
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func resolvePaths(dir string, paths []string) []string {
+	resolved := make([]string, len(paths))
+	for i, path := range paths {
+		resolved[i] = resolvePath(dir, path)
+	}
+	return resolved
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+//DefaultCacheDir is the directory where the outputs are cached when none is given
+const DefaultCacheDir = ".yanct-cache"
+
+const cacheOutputList = "outputs"
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of outputs",
+	Long: `Manage the cache of outputs.
+Every command that writes files caches its outputs keyed by the contents of its input files and the effective flags.
+When the same command runs again with unchanged inputs and flags the conversion is skipped, leaving the outputs in place or restoring them from the cache.`,
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove all cached outputs",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			return errors.New("cache clean does not accept arguments")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cleanCache()
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&runFlg.noCache, FlgNoCache, false, "Do not read nor write the cache of outputs")
+	rootCmd.PersistentFlags().StringVar(&runFlg.cacheDir, FlgCacheDir, DefaultCacheDir, "Directory of the cache of outputs")
+	cacheCmd.AddCommand(cacheCleanCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+//cached runs a job unless a previous run of it with the same inputs and flags is cached, then returns the job outputs
+func cached(job string, inputs []string, run func() ([]string, error)) ([]string, error) {
+	if runFlg.noCache {
+		return run()
+	}
+
+	key, err := cacheKey(job, inputs)
+	if err != nil {
+		// let the job report unreadable inputs
+		return run()
+	}
+
+	entry := filepath.Join(runFlg.cacheDir, key)
+	if outputs, err := restoreCacheEntry(entry); err == nil {
+		return outputs, nil
+	}
+
+	outputs, err := run()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storeCacheEntry(entry, outputs); err != nil {
+		return nil, err
+	}
+
+	// jobs like concat rewrite some of their inputs, so running them again over the rewritten inputs must be a hit too
+	if rekey, err := cacheKey(job, inputs); err == nil && rekey != key {
+		if err := storeCacheEntry(filepath.Join(runFlg.cacheDir, rekey), outputs); err != nil {
+			return nil, err
+		}
+	}
+
+	return outputs, nil
+}
+
+func cacheKey(job string, inputs []string) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%+v\n", job, flg)
+
+	for _, input := range inputs {
+		file, err := os.Open(input)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "%s\n", input)
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func restoreCacheEntry(entry string) ([]string, error) {
+	list, err := ioutil.ReadFile(filepath.Join(entry, cacheOutputList))
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for scanner.Scan() {
+		outputs = append(outputs, scanner.Text())
+	}
+
+	for i, output := range outputs {
+		cachedBytes, err := ioutil.ReadFile(filepath.Join(entry, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+
+		if outputBytes, err := ioutil.ReadFile(output); err == nil && bytes.Equal(outputBytes, cachedBytes) {
+			continue
+		}
+
+		if err := ioutil.WriteFile(output, cachedBytes, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return outputs, nil
+}
+
+func storeCacheEntry(entry string, outputs []string) error {
+	tmp := entry + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+
+	for i, output := range outputs {
+		outputBytes, err := ioutil.ReadFile(output)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmp, strconv.Itoa(i)), outputBytes, 0600); err != nil {
+			return err
+		}
+	}
+
+	var list bytes.Buffer
+	for _, output := range outputs {
+		fmt.Fprintln(&list, output)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, cacheOutputList), list.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(entry); err != nil {
+		return err
+	}
+	return os.Rename(tmp, entry)
+}
+
+func cleanCache() error {
+	entries, err := ioutil.ReadDir(runFlg.cacheDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// remove only what looks like a cache entry, in case the cache directory was pointed somewhere else
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".tmp")
+		if _, err := hex.DecodeString(name); !entry.IsDir() || err != nil || len(name) != sha256.Size*2 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(runFlg.cacheDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	if remaining, err := ioutil.ReadDir(runFlg.cacheDir); err == nil && len(remaining) == 0 {
+		return os.Remove(runFlg.cacheDir)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"image/color"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+var chr2tiledCmd = &cobra.Command{
+	Use:   "chr2tiled CHR",
+	Short: "Convert a CHR file into a Tiled tileset",
+	Long: `Convert a CHR file into a PNG image, with 16 tiles of 8x8 pixels per row, and a Tiled tileset (.tsx) of the image.
+Each tile of the tileset has the integer properties 'index', its index in the CHR, 'bank', its CHR bank, and 'palette', the palette it is drawn with.
+The image is indexed by the CHR colors, so the maps painted with the tileset convert back into the same tiles with tmx2nam.
+The colors come from a palette of the NES palette file or, without it, are grays.`,
+	Example: `Convert the file 'level.chr' into the files level.png and level.tsx, drawn with the 2nd palette of 'level.pal'
+
+yanct chr2tiled level.chr --palette-file=level.pal --pal=1`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 CHR file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validatePal(); err != nil {
+			return err
+		}
+		if err := validateBankTiles(); err != nil {
+			return err
+		}
+		return chr2tiled(args[0])
+	},
+}
+
+func init() {
+	chr2tiledCmd.Flags().Uint8VarP(&flg.pal, FlgPal, "p", 0, "Which palette to draw the tiles with [0,3] (default 0)")
+	chr2tiledCmd.Flags().StringVar(&flg.paletteFile, FlgPaletteFile, "", "Palette file with the 4 palettes (16 bytes)")
+	chr2tiledCmd.Flags().Uint16Var(&flg.bankTiles, FlgBankTiles, 256, "Amount of tiles in a CHR bank, e.g. 64 for 1KB banks")
+	chr2tiledCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output file name, without extension (default is the CHR file name)")
+	rootCmd.AddCommand(chr2tiledCmd)
+}
+
+func chr2tiled(filename string) error {
+	tileset, err := openCHR(filename, chr.Tile8x8)
+	if err != nil {
+		return err
+	}
+
+	palette := chr.TilePalette
+	if len(flg.paletteFile) > 0 {
+		palettes, err := openPalettes()
+		if err != nil {
+			return err
+		}
+		palette = color.Palette{}
+		for c := byte(0); c < 4; c++ {
+			palette = append(palette, chr.NESPalette[palettes.Color(flg.pal, c)])
+		}
+	}
+
+	outname := filename
+	if len(flg.fileOut) > 0 {
+		outname = flg.fileOut
+	}
+
+	return tileset.WriteTiled(outname, palette, flg.pal, int(flg.bankTiles))
+}
@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/parisoft/yanct/chr"
@@ -32,6 +34,9 @@ If a binary metasprite is found on the same path of a CHR file being appended, i
 			return err
 		}
 
+		if runFlg.watch {
+			return watch(cmd.Name(), func() []string { return concatInputs(args...) }, func() error { return concat(args...) })
+		}
 		return concat(args...)
 	},
 }
@@ -41,11 +46,43 @@ func init() {
 	concatCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "output CHR file name")
 	concatCmd.Flags().BoolVar(&flg.delMirror, FlgDelMirror, true, "Discard mirrored tiles")
 	concatCmd.Flags().BoolVar(&flg.delFlip, FlgDelFlip, true, "Discard flipped tiles")
+	concatCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs, including the metasprites found")
+	concatCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and concatenate the files again whenever they change")
 	concatCmd.MarkFlagRequired(FlgOutFile)
 	rootCmd.AddCommand(concatCmd)
 }
 
 func concat(chrlist ...string) error {
+	inputs := concatInputs(chrlist...)
+	pristines := make(map[string][]byte)
+	for _, chrfilename := range chrlist {
+		binfilename := siblingBin(chrfilename)
+		binbytes, err := ioutil.ReadFile(binfilename)
+		if err == nil {
+			pristines[binfilename] = pristineMetasprite(binfilename, binbytes)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	outputs, err := cached("concat", inputs, func() ([]string, error) { return concatCHR(pristines, chrlist...) })
+	if err != nil {
+		return err
+	}
+
+	for binfilename := range pristines {
+		written, err := ioutil.ReadFile(binfilename)
+		if err != nil {
+			return err
+		}
+		concatRewritten[binfilename] = rewrittenBin{pristine: pristines[binfilename], written: written}
+	}
+
+	return writeDepFile(depRule{outputs: outputs, inputs: inputs})
+}
+
+//concatCHR concatenates the CHR files, rewriting the binary metasprites found on the same path of them from their pristine bytes
+func concatCHR(pristines map[string][]byte, chrlist ...string) ([]string, error) {
 	tiledim := chr.Tile8x8
 	if flg.tileH == 16 {
 		tiledim = chr.Tile8x16
@@ -56,27 +93,21 @@ func concat(chrlist ...string) error {
 	for i, chrfilename := range chrlist {
 		chrfile, err := os.Open(chrfilename)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer chrfile.Close()
 
-		binfilename := chrfilename[:len(chrfilename)-3] + "bin"
+		binfilename := siblingBin(chrfilename)
 		binnames[i] = binfilename
-		binfile, err := os.Open(binfilename)
-		if err == nil {
-			defer binfile.Close()
-			var metaspr *chr.Metasprite
-			if metaspr, err = chr.NewMetaspriteFromFile(binfile); err != nil {
-				return err
+		if binbytes, ok := pristines[binfilename]; ok {
+			if metasprites[i], err = chr.NewMetaspriteFromBytes(binbytes); err != nil {
+				return nil, fmt.Errorf("Cannot read %s: %s", binfilename, err.Error())
 			}
-			metasprites[i] = metaspr
-		} else if !os.IsNotExist(err) {
-			return err
 		}
 
 		var tileset *chr.Tileset
 		if tileset, err = chr.NewTilesetFromCHR(chrfile, tiledim); err != nil {
-			return err
+			return nil, err
 		}
 		tilesets[i] = tileset
 	}
@@ -86,13 +117,53 @@ func concat(chrlist ...string) error {
 		chr.ConcatTiles(output, tileset, metasprites[i], flg.delMirror, flg.delFlip)
 	}
 
-	output.Write(flg.fileOut)
+	if err := output.Write(flg.fileOut); err != nil {
+		return nil, err
+	}
+	outputs := []string{chr.ChangeFileExtension(flg.fileOut, "chr")}
 
 	for i, metasprite := range metasprites {
 		if metasprite != nil {
-			metasprite.WriteBin(binnames[i])
+			if err := metasprite.WriteBin(binnames[i]); err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, binnames[i])
 		}
 	}
 
-	return nil
+	return outputs, nil
+}
+
+//rewrittenBin holds a binary metasprite before and after concat rewrote it
+type rewrittenBin struct {
+	pristine []byte
+	written  []byte
+}
+
+//concatRewritten holds the binary metasprites rewritten by concat, so running it again while watching does not remap their tiles twice
+var concatRewritten = make(map[string]rewrittenBin)
+
+//pristineMetasprite returns the bytes of a binary metasprite before concat rewrote it or, if it changed since then, its current bytes
+func pristineMetasprite(binfilename string, bytes []byte) []byte {
+	if rewritten, ok := concatRewritten[binfilename]; ok && string(rewritten.written) == string(bytes) {
+		return rewritten.pristine
+	}
+	concatRewritten[binfilename] = rewrittenBin{pristine: bytes}
+	return bytes
+}
+
+//concatInputs returns the CHR files and the binary metasprites found on the same path of them
+func concatInputs(chrlist ...string) []string {
+	inputs := append([]string{}, chrlist...)
+	for _, chrfilename := range chrlist {
+		if _, err := os.Stat(siblingBin(chrfilename)); err == nil {
+			inputs = append(inputs, siblingBin(chrfilename))
+		}
+	}
+	return inputs
+}
+
+//siblingBin returns the name of the binary metasprite on the same path of a CHR file
+func siblingBin(chrfilename string) string {
+	return chrfilename[:len(chrfilename)-3] + "bin"
 }
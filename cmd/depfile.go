@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+//depRule is a Make rule where the outputs of a job depend on its inputs
+type depRule struct {
+	outputs []string
+	inputs  []string
+}
+
+//writeDepFile writes the rules into a Make-compatible dependency file, when one is asked by the flags.
+//Every input also gets an empty rule, so make does not fail when it's removed.
+func writeDepFile(rules ...depRule) error {
+	if len(runFlg.depFile) == 0 {
+		return nil
+	}
+
+	depfile, err := os.OpenFile(runFlg.depFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer depfile.Close()
+
+	writer := bufio.NewWriter(depfile)
+	phony := make(map[string]bool)
+	var phonies []string
+	for _, rule := range rules {
+		isInput := make(map[string]bool)
+		for _, input := range rule.inputs {
+			isInput[input] = true
+		}
+
+		// inputs rewritten in place, like the metasprites updated by concat, would depend on themselves
+		var targets []string
+		for _, output := range rule.outputs {
+			if !isInput[output] {
+				targets = append(targets, escapeMake(output))
+			}
+		}
+
+		var prerequisites []string
+		for _, input := range rule.inputs {
+			prerequisites = append(prerequisites, escapeMake(input))
+			if !phony[input] {
+				phony[input] = true
+				phonies = append(phonies, input)
+			}
+		}
+
+		writer.WriteString(strings.Join(targets, " ") + ": " + strings.Join(prerequisites, " ") + "\n")
+	}
+
+	for _, input := range phonies {
+		writer.WriteString("\n" + escapeMake(input) + ":\n")
+	}
+
+	return writer.Flush()
+}
+
+func escapeMake(filename string) string {
+	filename = strings.Replace(filename, "$", "$$", -1)
+	filename = strings.Replace(filename, "#", "\\#", -1)
+	return strings.Replace(filename, " ", "\\ ", -1)
+}
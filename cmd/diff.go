@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff CHR_1 CHR_2",
+	Short: "Compare two CHR files tile by tile",
+	Long: `Compare two CHR files tile by tile.
+Reports the tiles added, removed or changed from the 1st file to the 2nd one.
+A tile that is equal, mirrored or flipped to a tile of the 1st file is reported as moved, mirrored or flipped from that tile, except the empty tiles.
+A tile of the 1st file not found in the 2nd one is reported as removed at its position in the 1st file.
+Optionally writes a PNG image with both files side by side, with the differing pixels highlighted in red.`,
+	Example: `Compare the files 'old.chr' and 'new.chr', writing the differences into 'diff.png'
+
+yanct diff old.chr new.chr --output=diff.png`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("diff requires 2 CHR files")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return diff(args[0], args[1])
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output PNG file name highlighting the differing pixels")
+	rootCmd.AddCommand(diffCmd)
+}
+
+func diff(chrname1, chrname2 string) error {
+	tileset1, err := openCHR(chrname1, chr.Tile8x8)
+	if err != nil {
+		return err
+	}
+	tileset2, err := openCHR(chrname2, chr.Tile8x8)
+	if err != nil {
+		return err
+	}
+
+	diffs := chr.Diff(tileset1, tileset2)
+	count := make(map[chr.TileChange]int)
+	for _, d := range diffs {
+		count[d.Change]++
+		if d.From >= 0 {
+			fmt.Printf("%-14s 0x%02x <- 0x%02x\n", d.Change, d.Idx, d.From)
+		} else {
+			fmt.Printf("%-14s 0x%02x\n", d.Change, d.Idx)
+		}
+	}
+
+	if len(diffs) == 0 {
+		fmt.Println("no differences")
+	} else {
+		fmt.Printf("%d added, %d removed, %d changed, %d moved, %d mirrored, %d flipped, %d mirror-flipped\n",
+			count[chr.TileAdded], count[chr.TileRemoved], count[chr.TileChanged], count[chr.TileMoved],
+			count[chr.TileMirrored], count[chr.TileFlipped], count[chr.TileMirrorFlipped])
+	}
+
+	if len(flg.fileOut) == 0 {
+		return nil
+	}
+
+	pngfile, err := os.OpenFile(flg.fileOut, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer pngfile.Close()
+
+	return png.Encode(pngfile, chr.DiffImage(tileset1, tileset2))
+}
+
+func openCHR(filename string, tiledim chr.TileDimension) (*chr.Tileset, error) {
+	chrfile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer chrfile.Close()
+
+	return chr.NewTilesetFromCHR(chrfile, tiledim)
+}
@@ -0,0 +1,428 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+//Amount of tile rows of the pattern table shown at once by the editor
+const editorVisibleRows = 8
+
+//NES colors marking the selected tile and the tile marked to be swapped in the pattern table of the editor
+const (
+	editorCursorColor = 0x28
+	editorMarkedColor = 0x2c
+)
+
+var editCmd = &cobra.Command{
+	Use:   "edit CHR",
+	Short: "Edit the tiles of a CHR file in the terminal",
+	Long: `Edit the tiles of a CHR file in a terminal with 24-bit colors.
+The pattern table is shown at the left, with the selected tile in yellow, and the selected tile zoomed at the right.
+With 8x16 tiles, each tile is selected together with the next one, shown below it in the zoom.
+The metasprites given with --meta keep pointing to the same tiles when tiles are swapped and are saved together with the CHR.
+Their 8x8 sprites point to the 1st pattern table and their 8x16 sprites to the pattern table given by the bit 0 of the tile,
+so tiles of different pattern tables are not swapped while editing metasprites.
+
+Keys:
+  arrows, h j k l   move the selected tile or, when painting, the selected pixel
+  enter, tab        start or stop painting
+  0 1 2 3           paint the selected pixel with a color
+  c, v              copy, paste the selected tile
+  m, f              mirror, flip the selected tile
+  s                 mark the selected tile, then swap it with the tile selected when pressed again
+  p                 draw with the next palette
+  w                 save the CHR and the metasprites
+  q                 quit, pressed twice when there are unsaved changes`,
+	Example: `Edit the tiles of 'hero.chr', made of 8x16 tiles, used by the metasprites 'hero.bin' and 'hero_mirrored.bin'
+
+yanct edit hero.chr --tile-height=16 --meta=hero.bin --meta=hero_mirrored.bin`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 CHR file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateTileH(); err != nil {
+			return err
+		}
+		if err := validatePal(); err != nil {
+			return err
+		}
+
+		ed, err := newEditor(args[0])
+		if err != nil {
+			return err
+		}
+		return ed.run(os.Stdin, os.Stdout)
+	},
+}
+
+func init() {
+	editCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
+	editCmd.Flags().Uint8VarP(&flg.pal, FlgPal, "p", 0, "Which palette to draw the tiles with [0,3] (default 0)")
+	editCmd.Flags().StringVar(&flg.paletteFile, FlgPaletteFile, "", "Palette file with the 4 sprite palettes (16 bytes)")
+	editCmd.Flags().StringArrayVar(&flg.metaFiles, FlgMeta, nil, "Binary metasprite file using the tiles, can be repeated")
+	rootCmd.AddCommand(editCmd)
+}
+
+type editor struct {
+	filename    string
+	tileset     *chr.Tileset
+	tiledim     chr.TileDimension
+	step        int // amount of tiles selected together
+	metanames   []string
+	metasprites []*chr.Metasprite
+	palettes    chr.Palettes
+	pal         byte
+	cursor      int // 1st selected tile
+	px, py      int // selected pixel of the selected tiles
+	painting    bool
+	marked      int // 1st tile marked to be swapped, -1 if none
+	clipboard   []chr.Tile
+	modified    bool
+	quitting    bool
+	top         int // 1st tile row shown
+	message     string
+}
+
+func newEditor(filename string) (*editor, error) {
+	tiledim := tileDimension()
+	tileset, err := openCHR(filename, tiledim)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := &editor{
+		filename: filename,
+		tileset:  tileset,
+		tiledim:  tiledim,
+		step:     tiledim.Height() / 8,
+		pal:      flg.pal,
+		marked:   -1,
+	}
+	if tileset.Size() == 0 || tileset.Size()%ed.step != 0 {
+		return nil, fmt.Errorf("CHR file %s has %d tiles, cannot edit them as %s tiles", filename, tileset.Size(), tiledim)
+	}
+
+	if ed.palettes, err = openPalettes(); err != nil {
+		return nil, err
+	}
+
+	for _, metaname := range flg.metaFiles {
+		metasprite, err := openMetasprite(metaname)
+		if err != nil {
+			return nil, err
+		}
+		ed.metanames = append(ed.metanames, metaname)
+		ed.metasprites = append(ed.metasprites, metasprite)
+	}
+
+	return ed, nil
+}
+
+//run reads the keys and draws the editor until it quits, with the terminal in raw mode
+func (ed *editor) run(in io.Reader, out io.Writer) error {
+	restore, err := rawTerminal()
+	if err != nil {
+		return fmt.Errorf("The editor requires a terminal: %s", err.Error())
+	}
+	defer restore()
+
+	// alternate screen without cursor, restored on exit
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprint(out, "\x1b[H\x1b[2J"+ed.render())
+
+		key, err := readKey(reader)
+		if err != nil {
+			return err
+		}
+		if ed.handle(key) {
+			return nil
+		}
+	}
+}
+
+//handle changes the editor by a key, returning true when it must quit
+func (ed *editor) handle(key string) bool {
+	ed.message = ""
+	if key != "q" {
+		ed.quitting = false
+	}
+
+	switch key {
+	case "up", "k":
+		ed.move(0, -1)
+	case "down", "j":
+		ed.move(0, 1)
+	case "left", "h":
+		ed.move(-1, 0)
+	case "right", "l":
+		ed.move(1, 0)
+	case "\r", "\n", "\t":
+		ed.painting = !ed.painting
+	case "0", "1", "2", "3":
+		if ed.painting {
+			ed.tileset.At(ed.cursor+ed.py/8).SetPixel(ed.px, ed.py%8, key[0]-'0')
+			ed.modified = true
+		} else {
+			ed.message = "Press enter to start painting"
+		}
+	case "c":
+		ed.clipboard = ed.clipboard[:0]
+		for k := 0; k < ed.step; k++ {
+			ed.clipboard = append(ed.clipboard, *ed.tileset.At(ed.cursor + k))
+		}
+		ed.message = fmt.Sprintf("Copied tile 0x%02x", ed.cursor)
+	case "v":
+		if len(ed.clipboard) == 0 {
+			ed.message = "Nothing to paste"
+			break
+		}
+		for k := range ed.clipboard {
+			ed.tileset.Set(ed.cursor+k, &ed.clipboard[k])
+		}
+		ed.modified = true
+	case "m":
+		for k := 0; k < ed.step; k++ {
+			ed.tileset.Set(ed.cursor+k, ed.tileset.At(ed.cursor+k).Mirror())
+		}
+		ed.modified = true
+	case "f":
+		// the tiles of a 8x16 tile also swap places when flipped
+		flipped := make([]*chr.Tile, ed.step)
+		for k := 0; k < ed.step; k++ {
+			flipped[ed.step-1-k] = ed.tileset.At(ed.cursor + k).Flip()
+		}
+		for k, tile := range flipped {
+			ed.tileset.Set(ed.cursor+k, tile)
+		}
+		ed.modified = true
+	case "s":
+		ed.swap()
+	case "p":
+		ed.pal = (ed.pal + 1) % 4
+	case "w":
+		if err := ed.save(); err != nil {
+			ed.message = err.Error()
+		} else {
+			ed.modified = false
+			ed.message = "Saved " + strings.Join(append([]string{ed.filename}, ed.metanames...), ", ")
+		}
+	case "q", "\x03":
+		if !ed.modified || ed.quitting || key == "\x03" {
+			return true
+		}
+		ed.quitting = true
+		ed.message = "There are unsaved changes, press q again to quit without saving"
+	}
+
+	return false
+}
+
+//move moves the selected pixel when painting, otherwise the selected tile
+func (ed *editor) move(dx, dy int) {
+	if ed.painting {
+		ed.px = (ed.px + dx + 8) % 8
+		ed.py = (ed.py + dy + ed.tiledim.Height()) % ed.tiledim.Height()
+		return
+	}
+
+	cursor := ed.cursor + dx*ed.step + dy*TilesPerRow
+	if cursor < 0 || cursor+ed.step > ed.tileset.Size() {
+		return
+	}
+	ed.cursor = cursor
+
+	row := ed.cursor / TilesPerRow
+	if row < ed.top {
+		ed.top = row
+	} else if row >= ed.top+editorVisibleRows {
+		ed.top = row - editorVisibleRows + 1
+	}
+}
+
+//swap marks the selected tile or swaps it with the marked one, keeping the metasprites pointing to the same tiles
+func (ed *editor) swap() {
+	switch ed.marked {
+	case -1:
+		ed.marked = ed.cursor
+		ed.message = fmt.Sprintf("Marked tile 0x%02x, select another tile and press s to swap them", ed.cursor)
+	case ed.cursor:
+		ed.marked = -1
+	default:
+		tables := chr.TilesetMaxRows * chr.TilesetMaxCols
+		table := ed.marked / tables
+		if len(ed.metasprites) > 0 && ed.cursor/tables != table {
+			ed.message = fmt.Sprintf("Cannot swap tiles 0x%02x and 0x%02x of different pattern tables, the metasprites cannot follow them", ed.marked, ed.cursor)
+			return
+		}
+
+		for k := 0; k < ed.step; k++ {
+			ed.tileset.Swap(ed.marked+k, ed.cursor+k)
+		}
+		// the 8x8 sprites point to the 1st pattern table, while the 8x16 sprites point to the one given by the bit 0 of their tile
+		if ed.step == 2 && table < 2 {
+			for _, metasprite := range ed.metasprites {
+				metasprite.SwapTiles(byte(ed.marked%tables|table), byte(ed.cursor%tables|table))
+			}
+		} else if table == 0 {
+			for _, metasprite := range ed.metasprites {
+				metasprite.SwapTiles(byte(ed.marked), byte(ed.cursor))
+			}
+		}
+		ed.message = fmt.Sprintf("Swapped tiles 0x%02x and 0x%02x", ed.marked, ed.cursor)
+		ed.marked = -1
+		ed.modified = true
+	}
+}
+
+func (ed *editor) save() error {
+	if err := ed.tileset.Write(ed.filename); err != nil {
+		return err
+	}
+	for i, metasprite := range ed.metasprites {
+		if err := metasprite.WriteBin(ed.metanames[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//render draws the pattern table, the zoom of the selected tile and the status, with the lines ended for a raw terminal
+func (ed *editor) render() string {
+	var zoom []string
+	for y := 0; y < ed.tiledim.Height(); y++ {
+		var line strings.Builder
+		for x := 0; x < 8; x++ {
+			color := ed.palettes.Color(ed.pal, ed.tileset.At(ed.cursor+y/8).Pixel(x, y%8))
+			r, g, b, _ := chr.NESPalette[color].RGBA()
+			if ed.painting && x == ed.px && y == ed.py {
+				fmt.Fprintf(&line, "\x1b[48;2;%d;%d;%dm\x1b[93m[]", r>>8, g>>8, b>>8)
+			} else {
+				fmt.Fprintf(&line, "\x1b[38;2;%d;%d;%dm██", r>>8, g>>8, b>>8)
+			}
+		}
+		zoom = append(zoom, line.String()+ansiReset)
+	}
+
+	var screen bytes.Buffer
+	for line := 0; line < editorVisibleRows*4; line++ {
+		row := ed.top + line/4
+		if row*TilesPerRow >= ed.tileset.Size() {
+			break
+		}
+
+		if line%4 == 0 {
+			fmt.Fprintf(&screen, "%02x ", row*TilesPerRow)
+		} else {
+			fmt.Fprint(&screen, "   ")
+		}
+		for i := row * TilesPerRow; i < (row+1)*TilesPerRow && i < ed.tileset.Size(); i++ {
+			for x := 0; x < 8; x++ {
+				y := (line % 4) * 2
+				fmt.Fprint(&screen, halfBlock(chr.NESPalette[ed.gridColor(i, x, y)], chr.NESPalette[ed.gridColor(i, x, y+1)]))
+			}
+		}
+		fmt.Fprint(&screen, ansiReset)
+
+		if line < len(zoom) {
+			fmt.Fprint(&screen, "  "+zoom[line])
+		}
+		fmt.Fprint(&screen, "\r\n")
+	}
+
+	mode, modified := "select", ""
+	if ed.painting {
+		mode = fmt.Sprintf("paint (%d,%d)", ed.px, ed.py)
+	}
+	if ed.modified {
+		modified = "  modified"
+	}
+	fmt.Fprintf(&screen, "\r\n%s  tile 0x%02x  pal %d  %s%s\r\n", ed.filename, ed.cursor, ed.pal, mode, modified)
+	fmt.Fprintf(&screen, "%s\r\n", ed.message)
+	fmt.Fprint(&screen, ansiDim+"arrows move  enter paint  0-3 color  c/v copy/paste  m/f mirror/flip  s swap  p palette  w save  q quit"+ansiReset+"\r\n")
+
+	return screen.String()
+}
+
+//gridColor returns the NES color of a pixel of a tile in the pattern table, with the color 0 of the selected and marked tiles highlighted
+func (ed *editor) gridColor(i, x, y int) byte {
+	color := ed.tileset.At(i).Pixel(x, y)
+	if color == 0 && i >= ed.cursor && i < ed.cursor+ed.step {
+		return editorCursorColor
+	}
+	if color == 0 && ed.marked >= 0 && i >= ed.marked && i < ed.marked+ed.step {
+		return editorMarkedColor
+	}
+	return ed.palettes.Color(ed.pal, color)
+}
+
+//readKey reads a key, naming the arrow keys as up, down, left and right.
+//A lone escape is a key by itself, as the terminal sends the bytes of an escape sequence at once.
+func readKey(reader *bufio.Reader) (string, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if b != 0x1b || reader.Buffered() == 0 {
+		return string(b), nil
+	}
+
+	next, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if next != '[' {
+		// not an escape sequence, so the next byte is read as the next key
+		return string(b), reader.UnreadByte()
+	}
+	code, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch code {
+	case 'A':
+		return "up", nil
+	case 'B':
+		return "down", nil
+	case 'C':
+		return "right", nil
+	case 'D':
+		return "left", nil
+	}
+	return "", nil
+}
+
+//rawTerminal puts the terminal in raw mode, without echo, returning a function that restores it
+func rawTerminal() (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(state)) }, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+//DefaultCharRange is the range of characters converted when none is given: the printable ASCII characters
+const DefaultCharRange = "32-126"
+
+var font2chrCmd = &cobra.Command{
+	Use:   "font2chr FONT",
+	Short: "Convert a BDF font into a CHR + character table files",
+	Long: `Convert a BDF bitmap font into a CHR + character table files.
+Each glyph of the range of characters is drawn into a tile of 8x8 pixels or, for 8x16, into 2 tiles in the order of the 8x16 sprites.
+The glyphs are placed on the baseline of the font, so the descent of the font stays below it, and must fit in the tile.
+The glyph of the character C goes to the position C + offset, so with --offset=-32 the space goes to the tile 0.
+With --table, the glyphs go to the positions given by a character table (.tbl) instead.
+The character table (.tbl) written with the CHR maps each character to its 1st tile, as HEX=CHAR lines, and can be used to encode texts.`,
+	Example: `Convert the printable ASCII characters of 'dialog.bdf' into the files dialog.chr and dialog.tbl, with the space at the tile 0
+
+yanct font2chr dialog.bdf --offset=-32
+
+Convert the digits and uppercase letters of 'score.bdf', drawn with the color 1 over the color 0, into 8x16 tiles
+
+yanct font2chr score.bdf --range=48-90 --fg-color=1 --tile-height=16
+
+Convert the characters of 'menu.tbl' from 'menu.bdf' into the files menu.chr and menu.tbl
+
+yanct font2chr menu.bdf --table=menu.tbl`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 BDF font file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateFont2chr(); err != nil {
+			return err
+		}
+
+		inputs := func() []string { return fontInputs(args[0]) }
+		if runFlg.watch {
+			return watch(cmd.Name(), inputs, func() error { return font2chr(args[0], inputs()) })
+		}
+		return font2chr(args[0], inputs())
+	},
+}
+
+func init() {
+	font2chrCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
+	font2chrCmd.Flags().Uint8Var(&flg.fgColor, FlgFgColor, 3, "Color index of the glyph pixels [0,3]")
+	font2chrCmd.Flags().Uint8VarP(&flg.bgColor, FlgBgColor, "b", 0, "Color index of the background [0,3] (default 0)")
+	font2chrCmd.Flags().StringVar(&flg.charRange, FlgRange, "", "Range of character codes to convert, as FIRST-LAST (default "+DefaultCharRange+")")
+	font2chrCmd.Flags().Int16Var(&flg.offset, FlgOffset, 0, "Value added to each character code to get its position in the CHR")
+	font2chrCmd.Flags().StringVar(&flg.table, FlgTable, "", "Character table (.tbl) giving the position of each character (replaces --range and --offset)")
+	font2chrCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output file name, without extension (default is the font file name)")
+	font2chrCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs")
+	font2chrCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and convert the font again whenever it or the character table changes")
+	rootCmd.AddCommand(font2chrCmd)
+}
+
+func validateFont2chr() error {
+	if err := validateTileH(); err != nil {
+		return err
+	}
+	if flg.fgColor > 3 {
+		return fmt.Errorf("Invalid foreground color index (%s): %d", FlgFgColor, flg.fgColor)
+	}
+	if err := validateBgColor(); err != nil {
+		return err
+	}
+	if flg.fgColor == flg.bgColor {
+		return fmt.Errorf("Flags %s and %s cannot have the same color index: %d", FlgFgColor, FlgBgColor, flg.fgColor)
+	}
+	if len(flg.table) > 0 && (len(flg.charRange) > 0 || flg.offset != 0) {
+		return fmt.Errorf("Flag %s cannot be used together with %s or %s", FlgTable, FlgRange, FlgOffset)
+	}
+	if _, _, err := charRange(); err != nil {
+		return err
+	}
+	return nil
+}
+
+//charRange returns the first and the last character codes of the range given by the flags
+func charRange() (int, int, error) {
+	value := flg.charRange
+	if len(value) == 0 {
+		value = DefaultCharRange
+	}
+
+	bounds := strings.SplitN(value, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("Invalid character range (%s): %s, expected FIRST-LAST", FlgRange, value)
+	}
+	first, err1 := strconv.ParseUint(strings.TrimSpace(bounds[0]), 0, 21)
+	last, err2 := strconv.ParseUint(strings.TrimSpace(bounds[1]), 0, 21)
+	if err1 != nil || err2 != nil || first > last {
+		return 0, 0, fmt.Errorf("Invalid character range (%s): %s, expected FIRST-LAST", FlgRange, value)
+	}
+
+	return int(first), int(last), nil
+}
+
+func fontInputs(filename string) []string {
+	if len(flg.table) > 0 {
+		return []string{filename, flg.table}
+	}
+	return []string{filename}
+}
+
+func font2chr(filename string, inputs []string) error {
+	outputs, err := cached("font2chr", inputs, func() ([]string, error) { return convertFont(filename) })
+	if err != nil {
+		return err
+	}
+
+	return writeDepFile(depRule{outputs: outputs, inputs: inputs})
+}
+
+func convertFont(filename string) ([]string, error) {
+	fontfile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fontfile.Close()
+
+	font, err := chr.ReadBDF(fontfile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read %s: %s", filename, err.Error())
+	}
+
+	positions, err := glyphPositions(font)
+	if err != nil {
+		return nil, err
+	}
+	tileset, table, err := chr.NewTilesetFromFont(font, positions, tileDimension(), flg.fgColor, flg.bgColor)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	outname := filename
+	if len(flg.fileOut) > 0 {
+		outname = flg.fileOut
+	}
+	if err := tileset.Write(outname); err != nil {
+		return nil, err
+	}
+	if err := table.Write(outname); err != nil {
+		return nil, err
+	}
+
+	return []string{chr.ChangeFileExtension(outname, "chr"), chr.ChangeFileExtension(outname, "tbl")}, nil
+}
+
+//glyphPositions returns the position in the CHR of each character to convert, from the character table or from the range and offset
+func glyphPositions(font *chr.Font) (map[rune]int, error) {
+	positions := make(map[rune]int)
+	if len(flg.table) > 0 {
+		table, err := openCharTable(flg.table)
+		if err != nil {
+			return nil, err
+		}
+		// the code of a 8x16 glyph is its 1st tile, so it must be even
+		perGlyph := tileDimension().Height() / 8
+		for _, chars := range table.Chars() {
+			code, _ := table.Code(chars)
+			if utf8.RuneCountInString(chars) != 1 || len(code) != 1 {
+				return nil, fmt.Errorf("Cannot map %X=%s of %s: a glyph needs a single character and a single byte code", code, chars, flg.table)
+			}
+			if int(code[0])%perGlyph != 0 {
+				return nil, fmt.Errorf("Cannot map %X=%s of %s: a glyph of %s pixels must start at an even tile", code, chars, flg.table, tileDimension())
+			}
+			char, _ := utf8.DecodeRuneInString(chars)
+			positions[char] = int(code[0]) / perGlyph
+		}
+		return positions, nil
+	}
+
+	first, last, err := charRange()
+	if err != nil {
+		return nil, err
+	}
+	for code := first; code <= last; code++ {
+		// characters missing from the font are left out of the CHR and of the character table
+		if _, ok := font.Glyph(rune(code)); ok {
+			positions[rune(code)] = code + int(flg.offset)
+		}
+	}
+
+	return positions, nil
+}
+
+func openCharTable(filename string) (*chr.CharTable, error) {
+	tblfile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer tblfile.Close()
+
+	table, err := chr.ReadCharTable(tblfile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read %s: %s", filename, err.Error())
+	}
+	return table, nil
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var gifCmd = &cobra.Command{
+	Use:   "gif CHR METASPR_1 [...METASPR_N] | gif ANIMATION",
+	Short: "Render metasprites as an animated GIF",
+	Long: `Render metasprites as an animated GIF, one frame per metasprite, for previews.
+The frames are drawn with the tiles of the CHR file and the colors of the NES palette, all sharing the same origin.
+The sprite palettes are read from a 16 bytes palette file, the first 4 bytes being the background palette 0, as loaded into the PPU.
+Without a palette file, the palettes 0 to 3 are drawn in gray, red, green and blue over black.
+The frames can also be declared in an animation file (.yaml), as:
+
+chr: hero.chr
+frames:
+  - metasprite: hero_walk1.bin
+    duration: 120
+  - metasprite: hero_walk2.bin
+    duration: 80
+
+Where the durations are in milliseconds and the paths are relative to the animation file.`,
+	Example: `Render the walk cycle of 'hero.chr', made of 8x16 tiles, with 100ms per frame at double size into 'hero.gif'
+
+yanct gif hero.chr walk1.bin walk2.bin walk3.bin --tile-height=16 --scale=2 --output=hero.gif
+
+Render the animation declared in 'walk.yaml' with the palettes of 'hero.pal'
+
+yanct gif walk.yaml --palette-file=hero.pal`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 1 && isAnimationFile(args[0]) {
+			return nil
+		}
+		if len(args) < 2 {
+			return errors.New("Requires 1 CHR file and at least 1 metasprite file, or 1 animation file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateGif(); err != nil {
+			return err
+		}
+
+		var anim *animation
+		var err error
+		if isAnimationFile(args[0]) {
+			anim, err = openAnimation(args[0])
+		} else {
+			anim, err = newAnimation(args[0], args[1:])
+		}
+		if err != nil {
+			return err
+		}
+
+		output := flg.fileOut
+		if len(output) == 0 {
+			output = chr.ChangeFileExtension(args[0], "gif")
+		}
+		return renderGif(anim, output)
+	},
+}
+
+func init() {
+	gifCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
+	gifCmd.Flags().StringVar(&flg.durations, FlgDurations, "100", "Comma separated duration in milliseconds of each frame, the last one is repeated for the remaining frames")
+	gifCmd.Flags().StringVar(&flg.paletteFile, FlgPaletteFile, "", "Palette file with the 4 sprite palettes (16 bytes)")
+	gifCmd.Flags().Uint8Var(&flg.scale, FlgScale, 1, "Integer scale of the image")
+	gifCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output GIF file name (default is the CHR or animation file name)")
+	rootCmd.AddCommand(gifCmd)
+}
+
+type animation struct {
+	CHR    string           `yaml:"chr"`
+	Frames []animationFrame `yaml:"frames"`
+}
+
+type animationFrame struct {
+	Metasprite string `yaml:"metasprite"`
+	Duration   int    `yaml:"duration"`
+}
+
+func validateGif() error {
+	if err := validateTileH(); err != nil {
+		return err
+	}
+	if flg.scale == 0 {
+		return fmt.Errorf("Invalid scale (%s): %d", FlgScale, flg.scale)
+	}
+	_, err := parseDurations()
+	return err
+}
+
+func isAnimationFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+//parseDurations parses the durations in milliseconds of the frames
+func parseDurations() ([]int, error) {
+	var durations []int
+	for _, field := range strings.Split(flg.durations, ",") {
+		duration, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+		if err != nil || duration == 0 {
+			return nil, fmt.Errorf("Invalid frame duration (%s): '%s'", FlgDurations, field)
+		}
+		durations = append(durations, int(duration))
+	}
+	return durations, nil
+}
+
+//newAnimation builds an animation with the durations asked by the flags
+func newAnimation(chrname string, metasprnames []string) (*animation, error) {
+	durations, err := parseDurations()
+	if err != nil {
+		return nil, err
+	}
+
+	anim := &animation{CHR: chrname}
+	for i, metasprname := range metasprnames {
+		duration := durations[len(durations)-1]
+		if i < len(durations) {
+			duration = durations[i]
+		}
+		anim.Frames = append(anim.Frames, animationFrame{Metasprite: metasprname, Duration: duration})
+	}
+
+	return anim, nil
+}
+
+//openAnimation reads an animation file, the frames without duration last the 1st duration asked by the flags
+func openAnimation(filename string) (*animation, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	anim := new(animation)
+	if err := yaml.UnmarshalStrict(bytes, anim); err != nil {
+		return nil, fmt.Errorf("Invalid animation %s: %s", filename, err.Error())
+	}
+	if len(anim.CHR) == 0 || len(anim.Frames) == 0 {
+		return nil, fmt.Errorf("Invalid animation %s: requires a chr and at least 1 frame", filename)
+	}
+
+	durations, err := parseDurations()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(filename)
+	anim.CHR = resolvePath(dir, anim.CHR)
+	for i := range anim.Frames {
+		anim.Frames[i].Metasprite = resolvePath(dir, anim.Frames[i].Metasprite)
+		if anim.Frames[i].Duration <= 0 {
+			anim.Frames[i].Duration = durations[0]
+		}
+	}
+
+	return anim, nil
+}
+
+func renderGif(anim *animation, output string) error {
+	tiledim := tileDimension()
+	tileset, err := openCHR(anim.CHR, tiledim)
+	if err != nil {
+		return err
+	}
+
+	palettes, err := openPalettes()
+	if err != nil {
+		return err
+	}
+
+	var metasprites []*chr.Metasprite
+	bounds := image.Rectangle{}
+	for _, frame := range anim.Frames {
+		metasprite, err := openMetasprite(frame.Metasprite)
+		if err != nil {
+			return err
+		}
+		metasprites = append(metasprites, metasprite)
+		bounds = bounds.Union(metasprite.Bounds(tiledim))
+	}
+	if bounds.Empty() {
+		bounds = image.Rect(0, 0, 8, tiledim.Height())
+	}
+
+	// every frame shares the origin, placed so the union of all frames fits the image
+	origin := image.Pt(-bounds.Min.X, -bounds.Min.Y)
+	anim8 := &gif.GIF{}
+	for i, metasprite := range metasprites {
+		frame := chr.NewCanvas(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palettes)
+		if err := metasprite.Draw(frame, origin, tileset, tiledim, palettes); err != nil {
+			return fmt.Errorf("Cannot draw %s: %s", anim.Frames[i].Metasprite, err.Error())
+		}
+
+		anim8.Image = append(anim8.Image, scaleImg(frame, int(flg.scale)))
+		// GIF delays are in hundredths of second
+		anim8.Delay = append(anim8.Delay, (anim.Frames[i].Duration+5)/10)
+	}
+
+	giffile, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer giffile.Close()
+
+	return gif.EncodeAll(giffile, anim8)
+}
+
+//openPalettes reads the palette file asked by the flags, or returns the default palettes
+func openPalettes() (chr.Palettes, error) {
+	if len(flg.paletteFile) == 0 {
+		return chr.DefaultPalettes, nil
+	}
+
+	palfile, err := os.Open(flg.paletteFile)
+	if err != nil {
+		return chr.Palettes{}, err
+	}
+	defer palfile.Close()
+
+	palettes, err := chr.NewPalettesFromFile(palfile)
+	if err != nil {
+		return palettes, fmt.Errorf("Invalid palette file (%s) %s: %s", FlgPaletteFile, flg.paletteFile, err.Error())
+	}
+	return palettes, nil
+}
+
+//scaleImg enlarges an image by an integer scale, repeating each pixel
+func scaleImg(img *image.Paletted, scale int) *image.Paletted {
+	if scale == 1 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	scaled := image.NewPaletted(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale), img.Palette)
+	for y := 0; y < scaled.Rect.Dy(); y++ {
+		for x := 0; x < scaled.Rect.Dx(); x++ {
+			scaled.SetColorIndex(x, y, img.ColorIndexAt(bounds.Min.X+x/scale, bounds.Min.Y+y/scale))
+		}
+	}
+	return scaled
+}
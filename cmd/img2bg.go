@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+//Stream orders of a background
+const (
+	StreamRows    = "rows"
+	StreamColumns = "columns"
+)
+
+var img2bgCmd = &cobra.Command{
+	Use:   "img2bg IMAGE",
+	Short: "Convert a PNG image into a CHR + nametable files",
+	Long: `Convert a PNG image into a CHR + nametable files for the background.
+The image is converted into a CHR with 8x8 tiles without duplicates, whose 1st tile is empty, and a nametable of the same dimension in tiles.
+The nametable is made of the tiles row by row followed by the attribute table, so an image of 256x240 pixels gives the 1024 bytes of a NES nametable.
+The image must be indexed with up to 16 colors, 4 per palette: the color index divided by 4 is the palette and the remainder is the CHR color, unless mapped with --color-map.
+The pixels of the CHR color 0 may have any palette, but the other pixels of each 16x16 block must share the same palette.
+Truecolor images of any dimension, like title screens and cutscenes, can be reduced to 4 colors of the NES palette with --dither, all drawn with the palette 0.
+The 4 colors are chosen to best represent the image, unless given by --sub-palette, and are written into a .pal file in the order of the CHR colors.
+The nametable can be compressed with the RLE format of vram_unrle of neslib or as a raw LZ4 block.
+
+With --metatile, the nametable is sliced into blocks of 16x16 or 32x32 pixels and the duplicated blocks are removed.
+Instead of the nametable, a metatile file is written with the block definition tables followed by the map of blocks, row by row.
+There is a table per tile position of the blocks, row by row, followed by a table with the palette of each 16x16 block or the attribute byte of each 32x32 block.
+The i-th byte of each table belongs to the i-th block. As C or assembly, each table has its own label.
+
+With --screens, the image is made of screens of 256x240 pixels sharing the same CHR and a nametable file is written per screen, suffixed by its column and row.
+The tiles added to the CHR by each screen are reported and the conversion fails if the screens have more than 256 different tiles.
+
+With --stream, a single nametable file is written for the whole image, for scrolling in 4 directions.
+With rows it is the tiles row by row followed by the attribute table row by row, as a nametable of any dimension.
+With columns it is the tiles column by column followed by the attribute table column by column, to stream the columns when scrolling horizontally.`,
+	Example: `Convert the image 'title.png', of 256x240 pixels, into the files title.chr and title.bin, a nametable of 1024 bytes
+
+yanct img2bg title.png
+
+Convert the image 'level1.png' into 16x16 metatiles formatted as assembly code into the files level1.chr and level1.inc,
+with the labels level1_tl, level1_tr, level1_bl, level1_br, level1_pal and level1_map
+
+yanct img2bg level1.png --metatile=16 --format=asm
+
+Convert the image 'title.png' into the files title.chr and title.bin, a nametable compressed for vram_unrle of neslib
+
+yanct img2bg title.png --compress=rle
+
+Convert the truecolor image 'cutscene.png', of 256x240 pixels, into the files cutscene.chr, cutscene.bin and cutscene.pal with the ordered dithering
+
+yanct img2bg cutscene.png --dither=bayer
+
+Convert the image 'stage1.png', of 512x480 pixels, into the files stage1.chr, stage1_0_0.bin, stage1_1_0.bin, stage1_0_1.bin and stage1_1_1.bin
+
+yanct img2bg stage1.png --screens`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 image file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateImg2bg(); err != nil {
+			return err
+		}
+		if runFlg.watch {
+			return watch(cmd.Name(), func() []string { return args }, func() error { return img2bg(args[0]) })
+		}
+		return img2bg(args[0])
+	},
+}
+
+func init() {
+	img2bgCmd.Flags().Uint8VarP(&flg.bgColor, FlgBgColor, "b", 0, "Color index of the background [0,3] (default 0)")
+	img2bgCmd.Flags().StringVarP(&flg.colorMap, FlgColorMap, "m", "", "Comma separated CHR color [0,3] of each image color index, e.g. 0,2,1,3 (replaces --bg-color)")
+	img2bgCmd.Flags().Uint8Var(&flg.metatile, FlgMetatile, 0, "Size in pixels of the metatiles: 16 for 16x16, 32 for 32x32 (default is no metatiles)")
+	img2bgCmd.Flags().StringVarP(&flg.format, FlgFormat, "f", "bin", "Nametable output format: c, asm, bin")
+	img2bgCmd.Flags().BoolVar(&flg.screens, FlgScreens, false, "Write a nametable per screen of 256x240 pixels")
+	img2bgCmd.Flags().StringVar(&flg.stream, FlgStream, "", "Write the nametable of the whole image as a stream of rows or columns: rows, columns")
+	img2bgCmd.Flags().StringVar(&flg.compress, FlgCompress, chr.CompressNone, "Compression of the nametable: none, rle (vram_unrle of neslib), lz4 (raw LZ4 block)")
+	img2bgCmd.Flags().StringVar(&flg.dither, FlgDither, "", "Reduce a truecolor image to 4 NES colors with a dithering: none, bayer, floyd (default is an indexed image)")
+	img2bgCmd.Flags().StringVar(&flg.subPalette, FlgSubPalette, "", "Comma separated hexadecimal NES colors of the reduced image, e.g. 0f,16,27,30 (default is chosen from the image)")
+	img2bgCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output file name, without extension (default is the image file name)")
+	img2bgCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs")
+	img2bgCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and convert the image again whenever it changes")
+	rootCmd.AddCommand(img2bgCmd)
+}
+
+func validateImg2bg() error {
+	if err := validateFormat(); err != nil {
+		return err
+	}
+	if err := validateCompress(); err != nil {
+		return err
+	}
+	if flg.stream != "" && flg.stream != StreamRows && flg.stream != StreamColumns {
+		return fmt.Errorf("Invalid stream order (%s): %s", FlgStream, flg.stream)
+	}
+	if flg.metatile > 0 && flg.compress != chr.CompressNone {
+		return fmt.Errorf("Flags %s and %s cannot be used together", FlgMetatile, FlgCompress)
+	}
+	if flg.metatile > 0 && (flg.screens || len(flg.stream) > 0) {
+		return fmt.Errorf("Flag %s cannot be used together with %s nor %s", FlgMetatile, FlgScreens, FlgStream)
+	}
+	if flg.screens && len(flg.stream) > 0 {
+		return fmt.Errorf("Flags %s and %s cannot be used together", FlgScreens, FlgStream)
+	}
+	if err := validateBgColor(); err != nil {
+		return err
+	}
+	if err := validateColorMap(); err != nil {
+		return err
+	}
+	if err := validateDither(); err != nil {
+		return err
+	}
+	return validateMetatile()
+}
+
+func validateMetatile() error {
+	if flg.metatile != 0 && flg.metatile != 16 && flg.metatile != 32 {
+		return fmt.Errorf("Invalid metatile size (%s): %d", FlgMetatile, flg.metatile)
+	}
+	return nil
+}
+
+//newBgColorMap returns the color map asked by the flags or, without it, the map of the 4 colors of each palette used by an image
+func newBgColorMap(img image.PalettedImage) (chr.ColorMap, error) {
+	if len(flg.colorMap) > 0 {
+		return newColorMap()
+	}
+
+	colors := chr.BackgroundMaxColors
+	if palette, ok := img.ColorModel().(color.Palette); ok && len(palette) < colors {
+		colors = len(palette)
+	}
+
+	palmap := chr.NewColorMap(flg.bgColor)
+	var colormap chr.ColorMap
+	for idx := 0; idx < colors; idx++ {
+		colormap = append(colormap, palmap.Map(byte(idx%4)))
+	}
+	return colormap, nil
+}
+
+func img2bg(filename string) error {
+	inputs := []string{filename}
+	outputs, err := cached("img2bg", inputs, func() ([]string, error) { return convertBg(filename) })
+	if err != nil {
+		return err
+	}
+
+	// the report comes from the image, so it is printed even when the outputs are restored from the cache
+	if flg.screens {
+		if err := printScreensReport(filename); err != nil {
+			return err
+		}
+	}
+
+	return writeDepFile(depRule{outputs: outputs, inputs: inputs})
+}
+
+func convertBg(filename string) ([]string, error) {
+	img, subpal, colormap, err := openBg(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	outname := filename
+	if len(flg.fileOut) > 0 {
+		outname = flg.fileOut
+	}
+
+	var outputs []string
+	if subpal != nil {
+		written, err := writeSubPalette(subpal, colormap, outname)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, written)
+	}
+
+	if flg.screens {
+		written, err := convertScreens(filename, img, colormap, outname)
+		return append(outputs, written...), err
+	}
+
+	tileset, nametable, err := chr.NewBackgroundFromPNG(img, colormap)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+	nametable.SetColumnOrder(flg.stream == StreamColumns)
+
+	if err := tileset.Write(outname); err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, chr.ChangeFileExtension(outname, "chr"))
+
+	var written []string
+	if flg.metatile > 0 {
+		written, err = writeMetatiles(nametable, outname)
+	} else {
+		written, err = writeNametable(nametable, outname)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	return append(outputs, written...), nil
+}
+
+//openBg opens the image of a background with its color map and, when reduced from a truecolor image, its NES colors
+func openBg(filename string) (image.PalettedImage, []byte, chr.ColorMap, error) {
+	img, subpal, err := openBgImg(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	colormap, err := newBgColorMap(img)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := colormap.Validate(img); err != nil {
+		return nil, nil, nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+	return img, subpal, colormap, nil
+}
+
+//openBgImg opens an indexed image or, with --dither, reduces a truecolor image to 4 NES colors returned with it
+func openBgImg(filename string) (image.PalettedImage, []byte, error) {
+	if len(flg.dither) == 0 {
+		img, err := openPalettedImg(filename)
+		return img, nil, err
+	}
+
+	img, err := openAnyImg(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return quantizeImg(filename, img, false)
+}
+
+//convertScreens converts an image made of screens into a CHR and a nametable per screen
+func convertScreens(filename string, img image.PalettedImage, colormap chr.ColorMap, outname string) ([]string, error) {
+	tileset, screens, err := chr.NewScreensFromPNG(img, colormap)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	if err := tileset.Write(outname); err != nil {
+		return nil, err
+	}
+	outputs := []string{chr.ChangeFileExtension(outname, "chr")}
+
+	for _, screen := range screens {
+		written, err := writeNametable(screen.Nametable, addSuffix(outname, fmt.Sprintf("_%d_%d", screen.X, screen.Y)))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+		}
+		outputs = append(outputs, written...)
+	}
+
+	return outputs, nil
+}
+
+//printScreensReport prints the tiles added to the CHR by each screen of an image
+func printScreensReport(filename string) error {
+	img, _, colormap, err := openBg(filename)
+	if err != nil {
+		return err
+	}
+	_, screens, err := chr.NewScreensFromPNG(img, colormap)
+	if err != nil {
+		return fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	total := 0
+	for _, screen := range screens {
+		total += len(screen.NewTiles)
+		fmt.Printf("screen (%d,%d): %3d new tiles, %3d total  %s\n", screen.X, screen.Y, len(screen.NewTiles), total, tileList(screen.NewTiles))
+	}
+	return nil
+}
+
+//writeMetatiles slices a nametable into the metatiles asked by the flags and writes them in the format asked by the flags, then returns the written files
+func writeMetatiles(nametable *chr.Nametable, filename string) ([]string, error) {
+	metatiles, err := chr.NewMetatiles(nametable, int(flg.metatile)/8)
+	if err != nil {
+		return nil, err
+	}
+
+	switch flg.format {
+	case MetaspriteOutputASM:
+		return []string{chr.ChangeFileExtension(filename, "inc")}, metatiles.WriteAsm(filename)
+	case MetaspriteOutputBin:
+		return []string{chr.ChangeFileExtension(filename, "bin")}, metatiles.WriteBin(filename)
+	case MetaspriteOutputC:
+		return []string{chr.ChangeFileExtension(filename, "c"), chr.ChangeFileExtension(filename, "h")}, metatiles.WriteC(filename)
+	}
+
+	return nil, fmt.Errorf("Invalid output format (%s): %s", FlgFormat, flg.format)
+}
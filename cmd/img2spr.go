@@ -3,6 +3,10 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"image"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/parisoft/yanct/chr"
 
@@ -16,17 +20,55 @@ const (
 	MetaspriteOutputBin = "bin"
 )
 
+//Suffixes of the file names and labels of the metasprite variants
+const (
+	MirroredSuffix = "_mirrored"
+	FlippedSuffix  = "_flipped"
+)
+
 var img2sprCmd = &cobra.Command{
 	Use:   "img2spr IMAGE_1 [...IMAGE_N]",
 	Short: "Convert a PNG image into a CHR + Metasprite file",
 	Long: `Convert a PNG image into a CHR + Metasprite file.
 First the image is converted into a CHR containing tiles of the choosen dimension, then all blank and duplicated tiles are removed.
 A metasprite file is also generated into the choosen format with the (0,0) axis pointing to the bottom left corner of the image.
-The image must be indexed with 4 colors and has the maximum dimension of 128x128 pixels.`,
+The image must be indexed with 4 colors and has the maximum dimension of 128x128 pixels.
+Images indexed with more colors can be converted by mapping each color index to a CHR color with --color-map.
+Truecolor images, like photos and gradients, can be reduced to 4 colors of the NES palette with --dither, which also writes the sub-palette used into a .pal file.
+The color 0 is always transparent, so the opaque pixels get only the colors [1,3], chosen to best represent the image unless given by --sub-palette.
+With --palette-file, a truecolor image is converted with the 4 sprite palettes of the file, as written by the palettes command:
+each tile takes the palette drawing it with the minimal error, reduced to its colors with the dithering of --dither (default none).`,
 	Example: `Convert the image 'sprite.png' into a CHR with 8x16 tiles and a metasprite formatted as C source code.
 This command will generate 1 file for CHR: sprite.chr and 2 files for metasprite: sprite.c and sprite.h
 
-yanct im2spr sprite.png --tile-height=16 --metasprite-format=c`,
+yanct im2spr sprite.png --tile-height=16 --metasprite-format=c
+
+Convert the image 'sprite.png', whose palette has 6 colors, swapping the colors 1 and 2 and merging the colors 4 and 5 into 3.
+
+yanct img2spr sprite.png --color-map=0,2,1,3,3,3
+
+Convert the image 'hero.png', 16 pixels wide, with the (0,0) axis pointing to its bottom center, also writing the metasprite facing the other side.
+This command will generate the files hero.chr, hero.bin and hero_mirrored.bin, both metasprites using the tiles of hero.chr
+
+yanct img2spr hero.png --dx=-8 --mirrored
+
+Convert the image 'hero.png' whose 5th color (index 4) marks its hitbox and must not be drawn.
+This command will generate the files hero.chr, hero.bin and hero_boxes.bin, with the bounding box followed by the hitbox
+
+yanct img2spr hero.png --color-map=0,1,2,3,0 --hitbox-color=4
+
+Convert the image 'door.png' whose colors 4, 5 and 6 are drawn as 1, 2 and 3 by the sprites behind the background
+
+yanct img2spr door.png --color-map=0,1,2,3,1,2,3 --priority-color=4,5,6
+
+Convert the truecolor image 'portrait.png' with the NES colors 0x0f, 0x07, 0x27 and 0x37 and the Floyd-Steinberg dithering.
+This command will generate the files portrait.chr, portrait.bin and portrait.pal, the sub-palette in the order of the CHR colors
+
+yanct img2spr portrait.png --dither=floyd --sub-palette=0f,07,27,37
+
+Convert the truecolor image 'hero.png' with the sprite palettes of 'sprites.pal', each sprite using the palette nearest to its tile
+
+yanct img2spr hero.png --palette-file=sprites.pal`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("Missing image file name")
@@ -34,17 +76,11 @@ yanct im2spr sprite.png --tile-height=16 --metasprite-format=c`,
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := validateMetasprFmt(); err != nil {
-			return err
-		}
-		if err := validateBgColor(); err != nil {
-			return err
-		}
-		if err := validateTileH(); err != nil {
+		if err := validateImg2spr(args...); err != nil {
 			return err
 		}
-		if err := validatePal(); err != nil {
-			return err
+		if runFlg.watch {
+			return watch(cmd.Name(), func() []string { return spriteInputs(args...) }, func() error { return convert(args...) })
 		}
 		return convert(args...)
 	},
@@ -53,50 +89,418 @@ yanct im2spr sprite.png --tile-height=16 --metasprite-format=c`,
 func init() {
 	img2sprCmd.Flags().Uint8VarP(&flg.pal, FlgPal, "p", 0, "Which palette to use [0,3] (default 0)")
 	img2sprCmd.Flags().Uint8VarP(&flg.bgColor, FlgBgColor, "b", 0, "Color index of the background [0,3] (default 0)")
+	img2sprCmd.Flags().StringVarP(&flg.colorMap, FlgColorMap, "m", "", "Comma separated CHR color [0,3] of each image color index, e.g. 0,2,1,3 (replaces --bg-color)")
 	img2sprCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
 	img2sprCmd.Flags().Int8Var(&flg.dx, FlgDx, 0, "Value to add/subtract to all X axis")
 	img2sprCmd.Flags().Int8Var(&flg.dy, FlgDy, 0, "Value to add/subtract to all Y axis")
+	img2sprCmd.Flags().BoolVar(&flg.mirrored, FlgMirrored, false, "Also write the metasprite horizontally mirrored around the pivot X, suffixed with "+MirroredSuffix)
+	img2sprCmd.Flags().BoolVar(&flg.flipped, FlgFlipped, false, "Also write the metasprite vertically mirrored around the pivot Y, suffixed with "+FlippedSuffix)
+	img2sprCmd.Flags().Int8Var(&flg.pivotX, FlgPivotX, 0, "X axis of the mirrored metasprite")
+	img2sprCmd.Flags().Int8Var(&flg.pivotY, FlgPivotY, 0, "Y axis of the flipped metasprite")
+	img2sprCmd.Flags().BoolVar(&flg.bbox, FlgBBox, false, "Also write the bounding box of the opaque pixels, suffixed with "+chr.BoxesSuffix)
+	img2sprCmd.Flags().StringVar(&flg.hitboxImg, FlgHitboxImg, "", "Image of the same dimension marking a hitbox with each color index but 0, written with the bounding box")
+	img2sprCmd.Flags().Uint8Var(&flg.hitboxColor, FlgHitboxColor, 0, "Color index marking the hitbox in the hitbox image or, without it, in the converted image")
+	img2sprCmd.Flags().StringVar(&flg.priorityImg, FlgPriorityImg, "", "Image of the same dimension marking with each color index but 0 the tiles drawn behind the background")
+	img2sprCmd.Flags().StringVar(&flg.priorityClr, FlgPriorityClr, "", "Comma separated color indexes marking the tiles drawn behind the background, e.g. 4,5,6")
+	img2sprCmd.Flags().BoolVar(&flg.behindBg, FlgBehindBg, false, "Draw all sprites behind the background")
+	img2sprCmd.Flags().StringVar(&flg.dither, FlgDither, "", "Reduce a truecolor image to 4 NES colors with a dithering: none, bayer, floyd (default is an indexed image)")
+	img2sprCmd.Flags().StringVar(&flg.subPalette, FlgSubPalette, "", "Comma separated hexadecimal NES colors of the reduced image, e.g. 0f,16,27,30 (default is chosen from the image)")
+	img2sprCmd.Flags().StringVar(&flg.paletteFile, FlgPaletteFile, "", "Palette file with the 4 sprite palettes (16 bytes) to convert a truecolor image, choosing the palette of each tile")
 	img2sprCmd.Flags().StringVarP(&flg.metasprFmt, FlgMetasprFmt, "f", "bin", "Metasprite output format: c, asm, bin")
 	img2sprCmd.Flags().BoolVar(&flg.delMirror, FlgDelMirror, true, "Discard mirrored tiles")
 	img2sprCmd.Flags().BoolVar(&flg.delFlip, FlgDelFlip, true, "Discard flipped tiles")
+	img2sprCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output file name, without extension, when converting a single image (default is the image file name)")
+	img2sprCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs of each image")
+	img2sprCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and convert the images again whenever they change")
 	rootCmd.AddCommand(img2sprCmd)
 }
 
+func validateImg2spr(filenames ...string) error {
+	if err := validateMetasprFmt(); err != nil {
+		return err
+	}
+	if err := validateBgColor(); err != nil {
+		return err
+	}
+	if err := validateColorMap(); err != nil {
+		return err
+	}
+	if err := validateTileH(); err != nil {
+		return err
+	}
+	if err := validatePal(); err != nil {
+		return err
+	}
+	if len(flg.fileOut) > 0 && len(filenames) > 1 {
+		return fmt.Errorf("Output file name (%s) is allowed only when converting a single image", FlgOutFile)
+	}
+	if len(flg.hitboxImg) > 0 && len(filenames) > 1 {
+		return fmt.Errorf("Hitbox image (%s) is allowed only when converting a single image", FlgHitboxImg)
+	}
+	if len(flg.priorityImg) > 0 && len(filenames) > 1 {
+		return fmt.Errorf("Priority image (%s) is allowed only when converting a single image", FlgPriorityImg)
+	}
+	if len(flg.priorityImg) > 0 && len(flg.priorityClr) > 0 {
+		return fmt.Errorf("Flags %s and %s cannot be used together", FlgPriorityImg, FlgPriorityClr)
+	}
+	if err := validateDither(); err != nil {
+		return err
+	}
+	if len(flg.paletteFile) > 0 && len(flg.subPalette) > 0 {
+		return fmt.Errorf("Flags %s and %s cannot be used together", FlgPaletteFile, FlgSubPalette)
+	}
+	if len(flg.paletteFile) > 0 && len(flg.colorMap) > 0 {
+		return fmt.Errorf("Flags %s and %s cannot be used together", FlgPaletteFile, FlgColorMap)
+	}
+	if len(flg.paletteFile) > 0 && flg.pal > 0 {
+		return fmt.Errorf("Flags %s and %s cannot be used together, the palette of each sprite comes from the palette file", FlgPaletteFile, FlgPal)
+	}
+	_, err := priorityColors()
+	return err
+}
+
+//priorityColors parses the color indexes marking the tiles behind the background
+func priorityColors() ([]byte, error) {
+	if len(flg.priorityClr) == 0 {
+		return nil, nil
+	}
+
+	var colors []byte
+	for _, field := range strings.Split(flg.priorityClr, ",") {
+		value, err := strconv.ParseUint(strings.TrimSpace(field), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid priority color index (%s): '%s'", FlgPriorityClr, field)
+		}
+		colors = append(colors, byte(value))
+	}
+	return colors, nil
+}
+
 func convert(filenames ...string) error {
+	var rules []depRule
 	for _, filename := range filenames {
-		pngimg, err := openImg(filename)
+		filename := filename
+		inputs := spriteInputs(filename)
+
+		outputs, err := cached("img2spr", inputs, func() ([]string, error) { return convertImg(filename) })
 		if err != nil {
 			return err
 		}
+		rules = append(rules, depRule{outputs: outputs, inputs: inputs})
+	}
+
+	return writeDepFile(rules...)
+}
+
+//spriteInputs returns the images followed by the files given by the flags that their conversion reads
+func spriteInputs(filenames ...string) []string {
+	inputs := append([]string{}, filenames...)
+	if len(flg.hitboxImg) > 0 {
+		inputs = append(inputs, flg.hitboxImg)
+	}
+	if len(flg.priorityImg) > 0 {
+		inputs = append(inputs, flg.priorityImg)
+	}
+	if len(flg.paletteFile) > 0 {
+		inputs = append(inputs, flg.paletteFile)
+	}
+	return inputs
+}
+
+func convertImg(filename string) ([]string, error) {
+	sprimg, err := openSpriteImg(filename)
+	if err != nil {
+		return nil, err
+	}
+	pngimg := sprimg.img
+
+	colormap, err := newImgColorMap(pngimg)
+	if err != nil {
+		return nil, err
+	}
+	if err := colormap.Validate(pngimg); err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	tileset := chr.NewTilesetFromPNG(pngimg, colormap)
+	metasprite := chr.NewMetaspriteFromTileset(tileset, flg.dx, flg.dy, flg.pal)
+	if sprimg.tilePalettes != nil {
+		metasprite.MarkPalettes(sprimg.tilePalettes)
+	}
+
+	mask, err := priorityMask(pngimg)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+	if mask != nil {
+		metasprite.MarkBehind(mask, tileDimension())
+	}
+	if flg.behindBg {
+		metasprite.SetBehind(true)
+	}
+
+	if flg.tileH == 16 {
+		tileset.To8x16()
+		metasprite.To8x16()
+	}
+
+	chr.CleanupTiles(tileset, metasprite, flg.delMirror, flg.delFlip)
 
-		tileset := chr.NewTilesetFromPNG(pngimg, flg.bgColor)
-		metasprite := chr.NewMetaspriteFromTileset(tileset, flg.dx, flg.dy, flg.pal)
+	outname := filename
+	if len(flg.fileOut) > 0 {
+		outname = flg.fileOut
+	}
+
+	err = tileset.Write(outname)
+	if err != nil {
+		return nil, err
+	}
+	outputs := []string{chr.ChangeFileExtension(outname, "chr")}
 
-		if flg.tileH == 16 {
-			tileset.To8x16()
-			metasprite.To8x16()
+	if sprimg.subpal != nil {
+		written, err := writeSubPalette(sprimg.subpal, colormap, outname)
+		if err != nil {
+			return nil, err
 		}
+		outputs = append(outputs, written)
+	}
 
-		chr.CleanupTiles(tileset, metasprite, flg.delMirror, flg.delFlip)
+	boxes, err := newBoxes(pngimg, colormap)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
 
-		err = tileset.Write(filename)
+	variants, err := metaspriteVariants(metasprite, boxes)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	for _, format := range metasprFormats() {
+		for _, variant := range variants {
+			written, err := writeMetasprite(variant.metasprite, addSuffix(outname, variant.suffix), format)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+			}
+			outputs = append(outputs, written...)
+
+			if variant.boxes != nil {
+				if written, err = writeBoxes(variant.boxes, addSuffix(outname, variant.suffix), format); err != nil {
+					return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+				}
+				outputs = append(outputs, written...)
+			}
+		}
+	}
+
+	return outputs, nil
+}
+
+//spriteImg is the indexed image to convert with, when reduced from a truecolor image, its NES colors or the palette of each tile
+type spriteImg struct {
+	img          image.PalettedImage
+	subpal       []byte
+	tilePalettes []byte
+}
+
+//openSpriteImg opens the image to convert or reduces a truecolor image to the NES colors of a sub-palette or of the palette file
+func openSpriteImg(filename string) (*spriteImg, error) {
+	if len(flg.dither) == 0 && len(flg.paletteFile) == 0 {
+		img, err := openImg(filename)
+		return &spriteImg{img: img}, err
+	}
+
+	img, err := openAnyImg(filename)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Dx() > 128 || img.Bounds().Dy() > 128 {
+		return nil, fmt.Errorf("Image '%s' has the maximum dimension of 128x128 pixels", filename)
+	}
+
+	if len(flg.paletteFile) > 0 {
+		dither := flg.dither
+		if len(dither) == 0 {
+			dither = chr.DitherNone
+		}
+		palettes, err := openPalettes()
 		if err != nil {
-			return err
+			return nil, err
+		}
+		quantized, tilePalettes, err := palettes.QuantizeTiles(img, tileDimension(), dither)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
 		}
+		return &spriteImg{img: quantized, tilePalettes: tilePalettes}, nil
+	}
+
+	quantized, subpal, err := quantizeImg(filename, img, true)
+	if err != nil {
+		return nil, err
+	}
+	return &spriteImg{img: quantized, subpal: subpal}, nil
+}
+
+type metaspriteVariant struct {
+	suffix     string
+	metasprite *chr.Metasprite
+	boxes      *chr.Boxes
+}
+
+//metaspriteVariants returns the metasprite followed by its mirrored and flipped variants asked by the flags, with their boxes if any
+func metaspriteVariants(metasprite *chr.Metasprite, boxes *chr.Boxes) ([]metaspriteVariant, error) {
+	variants := []metaspriteVariant{{"", metasprite, boxes}}
+
+	if flg.mirrored {
+		mirrored, err := metasprite.Mirror(int(flg.pivotX))
+		if err != nil {
+			return nil, err
+		}
+		var mirroredBoxes *chr.Boxes
+		if boxes != nil {
+			if mirroredBoxes, err = boxes.Mirror(int(flg.pivotX)); err != nil {
+				return nil, err
+			}
+		}
+		variants = append(variants, metaspriteVariant{MirroredSuffix, mirrored, mirroredBoxes})
+	}
 
-		switch flg.metasprFmt {
-		case MetaspriteOutputASM:
-			err = metasprite.WriteAsm(filename)
-		case MetaspriteOutputBin:
-			err = metasprite.WriteBin(filename)
-		case MetaspriteOutputC:
-			err = metasprite.WriteC(filename)
+	if flg.flipped {
+		for _, variant := range variants {
+			flipped, err := variant.metasprite.Flip(int(flg.pivotY), tileDimension())
+			if err != nil {
+				return nil, err
+			}
+			var flippedBoxes *chr.Boxes
+			if variant.boxes != nil {
+				if flippedBoxes, err = variant.boxes.Flip(int(flg.pivotY)); err != nil {
+					return nil, err
+				}
+			}
+			variants = append(variants, metaspriteVariant{variant.suffix + FlippedSuffix, flipped, flippedBoxes})
 		}
+	}
 
+	return variants, nil
+}
+
+//newBoxes returns the bounding box of the opaque pixels and the hitboxes asked by the flags, or nil if none is asked
+func newBoxes(img image.PalettedImage, colormap chr.ColorMap) (*chr.Boxes, error) {
+	if !flg.bbox && len(flg.hitboxImg) == 0 && flg.hitboxColor == 0 {
+		return nil, nil
+	}
+
+	bounds, err := chr.NewBox(img, func(idx byte) bool { return colormap.Map(idx) != 0 }, flg.dx, flg.dy)
+	if err != nil {
+		return nil, err
+	}
+	boxes := &chr.Boxes{Bounds: bounds}
+
+	markers := img
+	if len(flg.hitboxImg) > 0 {
+		if markers, err = openImg(flg.hitboxImg); err != nil {
+			return nil, err
+		}
+		if markers.Bounds().Size() != img.Bounds().Size() {
+			return nil, fmt.Errorf("Hitbox image '%s' must have the same dimension of the converted image", flg.hitboxImg)
+		}
+	}
+
+	for _, color := range hitboxColors(markers) {
+		color := color
+		hitbox, err := chr.NewBox(markers, func(idx byte) bool { return idx == color }, flg.dx, flg.dy)
+		if err != nil {
+			return nil, err
+		}
+		boxes.Hitboxes = append(boxes.Hitboxes, hitbox)
+	}
+
+	return boxes, nil
+}
+
+//priorityMask returns the tiles marked behind the background by the priority image or colors, or nil if none is asked
+func priorityMask(img image.PalettedImage) ([]bool, error) {
+	if len(flg.priorityImg) > 0 {
+		markers, err := openImg(flg.priorityImg)
 		if err != nil {
-			return fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+			return nil, err
+		}
+		if markers.Bounds().Size() != img.Bounds().Size() {
+			return nil, fmt.Errorf("Priority image '%s' must have the same dimension of the converted image", flg.priorityImg)
 		}
+		return chr.NewTileMaskFromPNG(markers, func(idx byte) bool { return idx != 0 }), nil
+	}
+
+	colors, err := priorityColors()
+	if err != nil || len(colors) == 0 {
+		return nil, err
+	}
+	return chr.NewTileMaskFromPNG(img, func(idx byte) bool {
+		for _, color := range colors {
+			if idx == color {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+//hitboxColors returns the color indexes that mark the hitboxes: the hitbox color if any, otherwise every color but 0 used by a hitbox image
+func hitboxColors(markers image.PalettedImage) []byte {
+	if flg.hitboxColor > 0 {
+		return []byte{flg.hitboxColor}
 	}
+	if len(flg.hitboxImg) == 0 {
+		return nil
+	}
+
+	var used [256]bool
+	bounds := markers.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			used[markers.ColorIndexAt(x, y)] = true
+		}
+	}
+
+	var colors []byte
+	for color := 1; color < len(used); color++ {
+		if used[color] {
+			colors = append(colors, byte(color))
+		}
+	}
+	return colors
+}
+
+//writeBoxes writes the boxes of a metasprite in the format asked by the flags, then returns the written files
+func writeBoxes(boxes *chr.Boxes, filename, format string) ([]string, error) {
+	boxesname := addSuffix(filename, chr.BoxesSuffix)
+	switch format {
+	case MetaspriteOutputASM:
+		return []string{chr.ChangeFileExtension(boxesname, "inc")}, boxes.WriteAsm(filename)
+	case MetaspriteOutputBin:
+		return []string{chr.ChangeFileExtension(boxesname, "bin")}, boxes.WriteBin(filename)
+	case MetaspriteOutputC:
+		return []string{chr.ChangeFileExtension(boxesname, "c"), chr.ChangeFileExtension(boxesname, "h")}, boxes.WriteC(filename)
+	}
+
+	return nil, fmt.Errorf("Invalid metasprite output format (%s): %s", FlgMetasprFmt, format)
+}
+
+//writeMetasprite writes a metasprite in the format asked by the flags, then returns the written files
+func writeMetasprite(metasprite *chr.Metasprite, filename, format string) ([]string, error) {
+	switch format {
+	case MetaspriteOutputASM:
+		return []string{chr.ChangeFileExtension(filename, "inc")}, metasprite.WriteAsm(filename)
+	case MetaspriteOutputBin:
+		return []string{chr.ChangeFileExtension(filename, "bin")}, metasprite.WriteBin(filename)
+	case MetaspriteOutputC:
+		return []string{chr.ChangeFileExtension(filename, "c"), chr.ChangeFileExtension(filename, "h")}, metasprite.WriteC(filename)
+	}
+
+	return nil, fmt.Errorf("Invalid metasprite output format (%s): %s", FlgMetasprFmt, format)
+}
 
-	return nil
+//addSuffix adds a suffix to a file name, before its extension
+func addSuffix(filename, suffix string) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + suffix + ext
 }
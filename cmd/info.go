@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info FILE_1 [...FILE_N]",
+	Short: "Show information about CHR and metasprite files",
+	Long: `Show information about CHR and metasprite files.
+For a CHR file (.chr) shows the amount of tiles, the empty tiles, the groups of duplicated tiles (exact, mirrored, flipped or both) and the occupancy of each bank.
+For a binary metasprite file (.bin) shows each sprite decoded, the bounding box and the highest amount of sprites sharing a scanline.
+The information is shown as a table or as JSON.`,
+	Example: `Show information about the files 'sprite.chr' and 'sprite.bin' as JSON, both with 8x16 tiles
+
+yanct info sprite.chr sprite.bin --tile-height=16 --json`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Missing CHR or metasprite file name")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateTileH(); err != nil {
+			return err
+		}
+		if err := validateBankTiles(); err != nil {
+			return err
+		}
+		return info(args...)
+	},
+}
+
+func init() {
+	infoCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
+	infoCmd.Flags().Uint16Var(&flg.bankTiles, FlgBankTiles, 256, "Amount of tiles in a CHR bank, e.g. 64 for 1KB banks")
+	infoCmd.Flags().BoolVar(&flg.json, FlgJSON, false, "Show the information as JSON")
+	rootCmd.AddCommand(infoCmd)
+}
+
+type chrInfo struct {
+	File          string      `json:"file"`
+	Type          string      `json:"type"`
+	Tiles         int         `json:"tiles"`
+	TileDimension string      `json:"tile_dimension"`
+	Empty         []int       `json:"empty"`
+	Duplicates    []tileGroup `json:"duplicates"`
+	Banks         []bankInfo  `json:"banks"`
+}
+
+type tileGroup struct {
+	Kind  string `json:"kind"`
+	Tiles []int  `json:"tiles"`
+}
+
+type bankInfo struct {
+	Tiles    int `json:"tiles"`
+	NonEmpty int `json:"non_empty"`
+	Capacity int `json:"capacity"`
+}
+
+type metaspriteInfo struct {
+	File         string       `json:"file"`
+	Type         string       `json:"type"`
+	Sprites      []spriteInfo `json:"sprites"`
+	Bounds       boxInfo      `json:"bounds"`
+	ScanlinePeak int          `json:"scanline_peak"`
+}
+
+type spriteInfo struct {
+	X       int8 `json:"x"`
+	Y       int8 `json:"y"`
+	Tile    byte `json:"tile"`
+	Palette byte `json:"palette"`
+	Mirror  bool `json:"mirror"`
+	Flip    bool `json:"flip"`
+	Behind  bool `json:"behind"`
+}
+
+type boxInfo struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func validateBankTiles() error {
+	if flg.bankTiles == 0 {
+		return fmt.Errorf("Invalid amount of tiles in a bank (%s): %d", FlgBankTiles, flg.bankTiles)
+	}
+	return nil
+}
+
+func info(filenames ...string) error {
+	tiledim := chr.Tile8x8
+	if flg.tileH == 16 {
+		tiledim = chr.Tile8x16
+	}
+
+	var infos []interface{}
+	for _, filename := range filenames {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".chr":
+			tileset, err := openCHR(filename, tiledim)
+			if err != nil {
+				return err
+			}
+			infos = append(infos, newCHRInfo(filename, tileset, tiledim))
+		case ".bin":
+			metasprite, err := openMetasprite(filename)
+			if err != nil {
+				return err
+			}
+			infos = append(infos, newMetaspriteInfo(filename, metasprite, tiledim))
+		default:
+			return fmt.Errorf("Unknown file type of '%s': expected a .chr or .bin file", filename)
+		}
+	}
+
+	if flg.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for i, inf := range infos {
+		if i > 0 {
+			fmt.Fprintln(writer)
+		}
+		switch inf := inf.(type) {
+		case *chrInfo:
+			printCHRInfo(writer, inf)
+		case *metaspriteInfo:
+			printMetaspriteInfo(writer, inf)
+		}
+	}
+
+	return writer.Flush()
+}
+
+func newCHRInfo(filename string, tileset *chr.Tileset, tiledim chr.TileDimension) *chrInfo {
+	inf := &chrInfo{
+		File:          filename,
+		Type:          "chr",
+		Tiles:         tileset.Size(),
+		TileDimension: string(tiledim),
+		Empty:         []int{},
+		Duplicates:    []tileGroup{},
+	}
+
+	for i := 0; i < tileset.Size(); i++ {
+		if tileset.At(i).Empty() {
+			inf.Empty = append(inf.Empty, i)
+		}
+	}
+
+	kinds := []struct {
+		name string
+		same func(tile, other *chr.Tile) bool
+	}{
+		{"exact", (*chr.Tile).Equals},
+		{"mirrored", (*chr.Tile).Mirrored},
+		{"flipped", (*chr.Tile).Flipped},
+		{"mirror-flipped", (*chr.Tile).MirrorFlipped},
+	}
+	grouped := make([]bool, tileset.Size())
+	for _, kind := range kinds {
+		for i := 0; i < tileset.Size(); i++ {
+			if grouped[i] || tileset.At(i).Empty() {
+				continue
+			}
+
+			group := tileGroup{Kind: kind.name, Tiles: []int{i}}
+			for j := i + 1; j < tileset.Size(); j++ {
+				if !grouped[j] && kind.same(tileset.At(j), tileset.At(i)) {
+					group.Tiles = append(group.Tiles, j)
+				}
+			}
+
+			if len(group.Tiles) > 1 {
+				for _, j := range group.Tiles[1:] {
+					grouped[j] = true
+				}
+				inf.Duplicates = append(inf.Duplicates, group)
+			}
+		}
+	}
+
+	capacity := int(flg.bankTiles)
+	for first := 0; first < tileset.Size(); first += capacity {
+		bank := bankInfo{Capacity: capacity}
+		for i := first; i < first+capacity && i < tileset.Size(); i++ {
+			bank.Tiles++
+			if !tileset.At(i).Empty() {
+				bank.NonEmpty++
+			}
+		}
+		inf.Banks = append(inf.Banks, bank)
+	}
+
+	return inf
+}
+
+func newMetaspriteInfo(filename string, metasprite *chr.Metasprite, tiledim chr.TileDimension) *metaspriteInfo {
+	bounds := metasprite.Bounds(tiledim)
+	inf := &metaspriteInfo{
+		File:         filename,
+		Type:         "metasprite",
+		Sprites:      []spriteInfo{},
+		Bounds:       boxInfo{X: bounds.Min.X, Y: bounds.Min.Y, Width: bounds.Dx(), Height: bounds.Dy()},
+		ScanlinePeak: metasprite.ScanlinePeak(tiledim),
+	}
+
+	for i := 0; i < metasprite.Size(); i++ {
+		spr := metasprite.At(i)
+		inf.Sprites = append(inf.Sprites, spriteInfo{
+			X:       spr.X,
+			Y:       spr.Y,
+			Tile:    spr.Idx,
+			Palette: spr.Palette(),
+			Mirror:  spr.Mirrored(),
+			Flip:    spr.Flipped(),
+			Behind:  spr.Behind(),
+		})
+	}
+
+	return inf
+}
+
+func printCHRInfo(writer *tabwriter.Writer, inf *chrInfo) {
+	fmt.Fprintf(writer, "%s\n", inf.File)
+	fmt.Fprintf(writer, "  tiles\t%d (%s)\n", inf.Tiles, inf.TileDimension)
+	fmt.Fprintf(writer, "  empty\t%d\t%s\n", len(inf.Empty), tileList(inf.Empty))
+	for _, group := range inf.Duplicates {
+		fmt.Fprintf(writer, "  %s\t%d\t%s\n", group.Kind, len(group.Tiles), tileList(group.Tiles))
+	}
+	for i, bank := range inf.Banks {
+		fmt.Fprintf(writer, "  bank %d\t%d/%d\t%d not empty\n", i, bank.Tiles, bank.Capacity, bank.NonEmpty)
+	}
+}
+
+func printMetaspriteInfo(writer *tabwriter.Writer, inf *metaspriteInfo) {
+	fmt.Fprintf(writer, "%s\n", inf.File)
+	fmt.Fprintln(writer, "  #\tX\tY\tTILE\tPAL\tMIRROR\tFLIP\tBEHIND")
+	for i, spr := range inf.Sprites {
+		fmt.Fprintf(writer, "  %d\t%d\t%d\t0x%02x\t%d\t%s\t%s\t%s\n", i, spr.X, spr.Y, spr.Tile, spr.Palette, yesNo(spr.Mirror), yesNo(spr.Flip), yesNo(spr.Behind))
+	}
+	// the sprite table has its own columns
+	writer.Flush()
+	fmt.Fprintf(writer, "  bounds\t(%d,%d) %dx%d\n", inf.Bounds.X, inf.Bounds.Y, inf.Bounds.Width, inf.Bounds.Height)
+	fmt.Fprintf(writer, "  scanline peak\t%d\n", inf.ScanlinePeak)
+}
+
+func tileList(tiles []int) string {
+	hex := make([]string, len(tiles))
+	for i, tile := range tiles {
+		hex[i] = fmt.Sprintf("0x%02x", tile)
+	}
+	return strings.Join(hex, " ")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "-"
+}
+
+func openMetasprite(filename string) (*chr.Metasprite, error) {
+	binfile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer binfile.Close()
+
+	metasprite, err := chr.NewMetaspriteFromFile(binfile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read %s: %s", filename, err.Error())
+	}
+	return metasprite, nil
+}
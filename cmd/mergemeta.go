@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"os"
 
 	"github.com/parisoft/yanct/chr"
@@ -25,27 +26,41 @@ Only the binary format is allowed.`,
 			return err
 		}
 
+		if runFlg.watch {
+			return watch(cmd.Name(), func() []string { return args }, func() error { return mergemeta(args...) })
+		}
 		return mergemeta(args...)
 	},
 }
 
 func init() {
 	mergemetaCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "output metasprite file name")
+	mergemetaCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the output and inputs")
+	mergemetaCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and merge the files again whenever they change")
 	rootCmd.AddCommand(mergemetaCmd)
 }
 
 func mergemeta(filenames ...string) error {
+	outputs, err := cached("mergemeta", filenames, func() ([]string, error) { return mergeMetasprites(filenames...) })
+	if err != nil {
+		return err
+	}
+
+	return writeDepFile(depRule{outputs: outputs, inputs: filenames})
+}
+
+func mergeMetasprites(filenames ...string) ([]string, error) {
 	metasprites := make([]*chr.Metasprite, len(filenames))
 	for i, filename := range filenames {
 		binfile, err := os.Open(filename)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer binfile.Close()
 
 		metasprites[i], err = chr.NewMetaspriteFromFile(binfile)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("Cannot read %s: %s", filename, err.Error())
 		}
 	}
 
@@ -57,7 +72,9 @@ func mergemeta(filenames ...string) error {
 	if len(output) == 0 {
 		output = filenames[0]
 	}
-	metasprites[0].WriteBin(output)
+	if err := metasprites[0].WriteBin(output); err != nil {
+		return nil, err
+	}
 
-	return nil
+	return []string{chr.ChangeFileExtension(output, "bin")}, nil
 }
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+var metaCmd = &cobra.Command{
+	Use:   "meta",
+	Short: "Edit metasprite files",
+	Long: `Edit metasprite files.
+Each subcommand reads a binary metasprite file, changes it and writes it back, or into another file in the choosen format.
+The tiles are not changed, so the edited metasprite still uses the same CHR.`,
+}
+
+var metaMirrorCmd = &cobra.Command{
+	Use:   "mirror METASPR",
+	Short: "Mirror a metasprite horizontally",
+	Long: `Mirror a metasprite horizontally around the vertical axis at the pivot X.
+The X of each sprite is negated and re-offset around the pivot and the mirror bit of each sprite is toggled.`,
+	Example: `Write the metasprite 'hero.bin', centered at X = 0, facing the other side into 'hero-left.bin'
+
+yanct meta mirror hero.bin --output=hero-left.bin`,
+	Args: metaArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			return metasprite.Mirror(int(flg.pivotX))
+		})
+	},
+}
+
+var metaFlipCmd = &cobra.Command{
+	Use:   "flip METASPR",
+	Short: "Mirror a metasprite vertically",
+	Long: `Mirror a metasprite vertically around the horizontal axis at the pivot Y.
+The Y of each sprite is negated and re-offset around the pivot, following the tile height, and the flip bit of each sprite is toggled.`,
+	Example: `Flip the metasprite 'hero.bin', made of 8x16 tiles, upside down around its bottom
+
+yanct meta flip hero.bin --tile-height=16`,
+	Args: metaArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			return metasprite.Flip(int(flg.pivotY), tileDimension())
+		})
+	},
+}
+
+//Priority edits
+const (
+	PriorityBehind = "behind"
+	PriorityFront  = "front"
+	PriorityToggle = "toggle"
+)
+
+var metaTranslateCmd = &cobra.Command{
+	Use:   "translate METASPR",
+	Short: "Move all sprites of a metasprite",
+	Long: `Move all sprites of a metasprite, adding dx to their X and dy to their Y.
+Fails if any sprite would be moved out of the range [-128,127].`,
+	Example: `Move the origin of the metasprite 'hero.bin' 8 pixels to the right, centering a 16 pixels wide metasprite
+
+yanct meta translate hero.bin --dx=-8`,
+	Args: metaArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			return metasprite.Translate(int(flg.dx), int(flg.dy))
+		})
+	},
+}
+
+var metaPalCmd = &cobra.Command{
+	Use:   "pal METASPR",
+	Short: "Set the palette of all sprites of a metasprite",
+	Example: `Draw the metasprite 'enemy.bin' with the 3rd sprite palette
+
+yanct meta pal enemy.bin --pal=2`,
+	Args: metaArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validatePal(); err != nil {
+			return err
+		}
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			return metasprite, metasprite.SetPalette(flg.pal)
+		})
+	},
+}
+
+var metaPriorityCmd = &cobra.Command{
+	Use:   "priority METASPR",
+	Short: "Set, clear or toggle the priority bit of all sprites of a metasprite",
+	Long: `Set, clear or toggle the priority bit of all sprites of a metasprite.
+The sprites with the priority bit are drawn behind the background.
+By default the bit is set, so running the edit again gives the same metasprite, while toggle must be asked.`,
+	Example: `Draw the metasprite 'ghost.bin' behind the background
+
+yanct meta priority ghost.bin
+
+Swap the sprites of the metasprite 'door.bin' drawn behind the background with the ones drawn in front of it
+
+yanct meta priority door.bin --priority=toggle`,
+	Args: metaArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validatePriority(); err != nil {
+			return err
+		}
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			switch flg.priority {
+			case PriorityBehind:
+				metasprite.SetBehind(true)
+			case PriorityFront:
+				metasprite.SetBehind(false)
+			case PriorityToggle:
+				metasprite.ToggleBehind()
+			}
+			return metasprite, nil
+		})
+	},
+}
+
+var metaDeleteCmd = &cobra.Command{
+	Use:   "delete METASPR IDX_1 [...IDX_N]",
+	Short: "Delete sprites from a metasprite",
+	Long: `Delete sprites from a metasprite by their position, starting at 0, as shown by the info command.`,
+	Example: `Delete the 1st and the 4th sprites of the metasprite 'hero.bin'
+
+yanct meta delete hero.bin 0 3`,
+	Args: metaIndexArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		positions, err := parsePositions(args[1:])
+		if err != nil {
+			return err
+		}
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			return metasprite.Delete(positions...)
+		})
+	},
+}
+
+var metaReorderCmd = &cobra.Command{
+	Use:   "reorder METASPR IDX_1 [...IDX_N]",
+	Short: "Reorder the sprites of a metasprite",
+	Long: `Reorder the sprites of a metasprite by their position, starting at 0, as shown by the info command.
+The given sprites are moved to the beginning of the metasprite in the given order, followed by the other sprites in their current order.
+The first sprites are drawn over the others.`,
+	Example: `Draw the 3rd sprite of the metasprite 'hero.bin' over the others
+
+yanct meta reorder hero.bin 2`,
+	Args: metaIndexArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		positions, err := parsePositions(args[1:])
+		if err != nil {
+			return err
+		}
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			return metasprite.Reorder(positions...)
+		})
+	},
+}
+
+var metaSortCmd = &cobra.Command{
+	Use:   "sort METASPR",
+	Short: "Sort the sprites of a metasprite for drawing",
+	Long: `Sort the sprites of a metasprite for drawing, keeping the order of the sprites that compare equal.
+The first sprites are drawn over the others. The orders are:
+y: from top to bottom, then from left to right
+x: from left to right, then from top to bottom
+front: the sprites in front of the background before the sprites behind it`,
+	Example: `Sort the metasprite 'hero.bin' from left to right
+
+yanct meta sort hero.bin --order=x`,
+	Args: metaArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editMetasprite(args[0], func(metasprite *chr.Metasprite) (*chr.Metasprite, error) {
+			sorted, err := metasprite.Sort(flg.order)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid sort order (%s): %s", FlgOrder, flg.order)
+			}
+			return sorted, nil
+		})
+	},
+}
+
+func init() {
+	metaTranslateCmd.Flags().Int8Var(&flg.dx, FlgDx, 0, "Value to add/subtract to all X axis")
+	metaTranslateCmd.Flags().Int8Var(&flg.dy, FlgDy, 0, "Value to add/subtract to all Y axis")
+	metaPalCmd.Flags().Uint8VarP(&flg.pal, FlgPal, "p", 0, "Which palette to use [0,3] (default 0)")
+	metaPriorityCmd.Flags().StringVar(&flg.priority, FlgPriority, PriorityBehind, "Priority edit: behind, front, toggle")
+	metaSortCmd.Flags().StringVar(&flg.order, FlgOrder, chr.SortByY, "Sort order: y, x, front")
+	metaMirrorCmd.Flags().Int8Var(&flg.pivotX, FlgPivotX, 0, "X axis of the mirror")
+	metaFlipCmd.Flags().Int8Var(&flg.pivotY, FlgPivotY, 0, "Y axis of the flip")
+	metaFlipCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
+
+	for _, cmd := range []*cobra.Command{metaTranslateCmd, metaPalCmd, metaPriorityCmd, metaMirrorCmd, metaFlipCmd, metaDeleteCmd, metaReorderCmd, metaSortCmd} {
+		cmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output metasprite file name (default is the input file name)")
+		cmd.Flags().StringVarP(&flg.metasprFmt, FlgMetasprFmt, "f", "bin", "Metasprite output format: c, asm, bin")
+		metaCmd.AddCommand(cmd)
+	}
+
+	rootCmd.AddCommand(metaCmd)
+}
+
+func metaArgs(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Requires 1 metasprite file")
+	}
+	return nil
+}
+
+func metaIndexArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return errors.New("Requires 1 metasprite file and at least 1 sprite index")
+	}
+	return nil
+}
+
+func validatePriority() error {
+	if flg.priority != PriorityBehind && flg.priority != PriorityFront && flg.priority != PriorityToggle {
+		return fmt.Errorf("Invalid priority edit (%s): %s", FlgPriority, flg.priority)
+	}
+	return nil
+}
+
+//parsePositions parses the positions of sprites, in decimal or hexadecimal with 0x
+func parsePositions(args []string) ([]int, error) {
+	var positions []int
+	for _, arg := range args {
+		pos, err := strconv.ParseUint(arg, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid sprite index: %s", arg)
+		}
+		positions = append(positions, int(pos))
+	}
+	return positions, nil
+}
+
+//editMetasprite reads a metasprite file, edits it and writes the edited metasprite
+func editMetasprite(filename string, edit func(*chr.Metasprite) (*chr.Metasprite, error)) error {
+	if err := validateMetasprFmt(); err != nil {
+		return err
+	}
+	if err := validateTileH(); err != nil {
+		return err
+	}
+
+	metasprite, err := openMetasprite(filename)
+	if err != nil {
+		return err
+	}
+
+	edited, err := edit(metasprite)
+	if err != nil {
+		return err
+	}
+
+	output := filename
+	if len(flg.fileOut) > 0 {
+		output = flg.fileOut
+	}
+
+	_, err = writeMetasprite(edited, output, flg.metasprFmt)
+	return err
+}
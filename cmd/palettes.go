@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+//DefaultPalettesFile is the name of the palette file written when none is given
+const DefaultPalettesFile = "palettes"
+
+//UniversalColor is the color 0 of the chosen palettes, the universal background color, as the color 0 of the sprites is transparent
+const UniversalColor = 0x0f
+
+var palettesCmd = &cobra.Command{
+	Use:   "palettes IMAGE_1 [...IMAGE_N]",
+	Short: "Choose the sprite palettes of many truecolor images",
+	Long: `Choose up to 4 sprite palettes from the NES palette drawing the tiles of truecolor PNG images with the minimal error.
+Each tile of 8x8 or 8x16 pixels is drawn with a single palette of 3 colors, its transparent pixels with the color 0.
+The palettes are written into a palette file of 16 bytes, with the universal background color 0x0f as the color 0, from the palette drawing the most pixels.
+Then a report tells the palette of each tile of each image, as columns and rows of tiles, and how many pixels have no exact color in their palette.
+The palette file can be given to img2spr with --palette-file to convert the images.`,
+	Example: `Choose the palettes of the images 'hero.png' and 'enemy.png', with 8x16 tiles, into the file sprites.pal
+
+yanct palettes hero.png enemy.png --tile-height=16 --output=sprites
+
+Then convert each image with the chosen palettes
+
+yanct img2spr hero.png enemy.png --tile-height=16 --palette-file=sprites.pal`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Missing image file name")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateTileH(); err != nil {
+			return err
+		}
+		// the output flag is shared by many commands, so its default is applied here
+		if len(flg.fileOut) == 0 {
+			flg.fileOut = DefaultPalettesFile
+		}
+		if runFlg.watch {
+			return watch(cmd.Name(), func() []string { return args }, func() error { return palettes(args...) })
+		}
+		return palettes(args...)
+	},
+}
+
+func init() {
+	palettesCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
+	palettesCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output palette file name, without extension (default is "+DefaultPalettesFile+")")
+	palettesCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs")
+	palettesCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and choose the palettes again whenever the images change")
+	rootCmd.AddCommand(palettesCmd)
+}
+
+func palettes(filenames ...string) error {
+	var imgs []image.Image
+	for _, filename := range filenames {
+		img, err := openAnyImg(filename)
+		if err != nil {
+			return err
+		}
+		imgs = append(imgs, img)
+	}
+
+	outputs, err := cached("palettes", filenames, func() ([]string, error) {
+		palettes, _ := chr.NewSpritePalettes(imgs, tileDimension(), UniversalColor)
+		return []string{chr.ChangeFileExtension(flg.fileOut, "pal")}, palettes.Write(flg.fileOut)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the report comes from the written palettes, so it is printed even when they are restored from the cache
+	flg.paletteFile = outputs[0]
+	palettes, err := openPalettes()
+	if err != nil {
+		return err
+	}
+	printPalettesReport(palettes, filenames, imgs)
+
+	return writeDepFile(depRule{outputs: outputs, inputs: filenames})
+}
+
+//printPalettesReport prints the palettes, then the palette of each tile of each image
+func printPalettesReport(palettes chr.Palettes, filenames []string, imgs []image.Image) {
+	tiledim := tileDimension()
+	type usage struct {
+		tiles  [len(chr.Palettes{})][]string
+		missed int
+	}
+
+	usages := make([]usage, len(imgs))
+	var tileCount [len(chr.Palettes{})]int
+	for i, img := range imgs {
+		bounds := img.Bounds()
+		for _, rect := range chr.TileRects(img, tiledim) {
+			if !opaqueRect(img, rect) {
+				continue
+			}
+			pal, missed := palettes.NearestPalette(img, rect, len(palettes))
+			col, row := (rect.Min.X-bounds.Min.X)/8, (rect.Min.Y-bounds.Min.Y)/tiledim.Height()
+			usages[i].tiles[pal] = append(usages[i].tiles[pal], fmt.Sprintf("(%d,%d)", col, row))
+			usages[i].missed += missed
+			tileCount[pal]++
+		}
+	}
+
+	for pal, colors := range palettes {
+		if tileCount[pal] == 0 {
+			fmt.Printf("palette %d: unused\n", pal)
+			continue
+		}
+		fmt.Printf("palette %d: %02x %02x %02x %02x, %d tiles\n", pal, colors[0], colors[1], colors[2], colors[3], tileCount[pal])
+	}
+	for i, filename := range filenames {
+		fmt.Println(filename)
+		for pal, tiles := range usages[i].tiles {
+			if len(tiles) > 0 {
+				fmt.Printf("  palette %d: %s\n", pal, strings.Join(tiles, " "))
+			}
+		}
+		fmt.Printf("  %d pixels without their exact color\n", usages[i].missed)
+	}
+}
+
+//opaqueRect tells if any pixel of a region of an image is opaque
+func opaqueRect(img image.Image, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a >= 0x8000 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,39 +3,143 @@ package cmd
 import (
 	"fmt"
 	"image"
+	"image/color"
 	"image/png"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/parisoft/yanct/chr"
 	"github.com/spf13/cobra"
 )
 
 //Flag names
 const (
-	FlgPal        = "pal"
-	FlgBgColor    = "bg-color"
-	FlgTileH      = "tile-height"
-	FlgMetasprFmt = "metasprite-format"
-	FlgOutFile    = "output"
-	FlgDx         = "dx"
-	FlgDy         = "dy"
-	FlgDelMirror  = "del-mirror"
-	FlgDelFlip	  = "del-flip"
+	FlgPal         = "pal"
+	FlgBgColor     = "bg-color"
+	FlgTileH       = "tile-height"
+	FlgMetasprFmt  = "metasprite-format"
+	FlgOutFile     = "output"
+	FlgDx          = "dx"
+	FlgDy          = "dy"
+	FlgDelMirror   = "del-mirror"
+	FlgDelFlip     = "del-flip"
+	FlgColorMap    = "color-map"
+	FlgBankTiles   = "bank-tiles"
+	FlgJSON        = "json"
+	FlgMirrored    = "mirrored"
+	FlgFlipped     = "flipped"
+	FlgPivotX      = "pivot-x"
+	FlgPivotY      = "pivot-y"
+	FlgBBox        = "bbox"
+	FlgHitboxImg   = "hitbox-image"
+	FlgHitboxColor = "hitbox-color"
+	FlgPriorityImg = "priority-image"
+	FlgPriorityClr = "priority-color"
+	FlgBehindBg    = "behind-bg"
+	FlgPriority    = "priority"
+	FlgOrder       = "order"
+	FlgDurations   = "durations"
+	FlgPaletteFile = "palette-file"
+	FlgScale       = "scale"
+	FlgHighlight   = "highlight"
+	FlgMeta        = "meta"
+	FlgFormat      = "format"
+	FlgLayer       = "layer"
+	FlgMetatile    = "metatile"
+	FlgCompress    = "compress"
+	FlgScreens     = "screens"
+	FlgStream      = "stream"
+	FlgAddr        = "addr"
+	FlgFirst       = "first"
+	FlgCount       = "count"
+	FlgX           = "x"
+	FlgY           = "y"
+	FlgWidth       = "width"
+	FlgHeight      = "height"
+	FlgVertical    = "vertical"
+	FlgBudget      = "budget"
+	FlgVramFmt     = "vram-format"
+	FlgFgColor     = "fg-color"
+	FlgRange       = "range"
+	FlgOffset      = "offset"
+	FlgTable       = "table"
+	FlgDither      = "dither"
+	FlgSubPalette  = "sub-palette"
+	FlgNoCache     = "no-cache"
+	FlgCacheDir    = "cache-dir"
+	FlgDepFile     = "dep-file"
+	FlgWatch       = "watch"
 )
 
 type flag struct {
-	pal        uint8
-	bgColor    uint8
-	tileH      uint8
-	metasprFmt string
-	fileOut    string
-	dx         int8
-	dy         int8
-	delMirror  bool
-	delFlip    bool
+	pal         uint8
+	bgColor     uint8
+	tileH       uint8
+	metasprFmt  string
+	metasprFmts []string // formats written from a single conversion, instead of metasprFmt
+	fileOut     string
+	dx          int8
+	dy          int8
+	delMirror   bool
+	delFlip     bool
+	colorMap    string
+	bankTiles   uint16
+	json        bool
+	mirrored    bool
+	flipped     bool
+	pivotX      int8
+	pivotY      int8
+	bbox        bool
+	hitboxImg   string
+	hitboxColor uint8
+	priorityImg string
+	priorityClr string
+	behindBg    bool
+	priority    string
+	order       string
+	durations   string
+	paletteFile string
+	scale       uint8
+	highlight   bool
+	metaFiles   []string
+	format      string
+	layer       string
+	metatile    uint8
+	compress    string
+	screens     bool
+	stream      string
+	tileAddr    uint16
+	ntAddr      uint16
+	first       uint16
+	count       uint16
+	x           uint8
+	y           uint8
+	width       uint8
+	height      uint8
+	vertical    bool
+	budget      uint16
+	vramFmt     string
+	fgColor     uint8
+	charRange   string
+	offset      int16
+	table       string
+	dither      string
+	subPalette  string
 }
 
 var flg flag
 
+//runFlag holds the flags that change how a command runs, but not what it outputs
+type runFlag struct {
+	noCache  bool
+	cacheDir string
+	depFile  string
+	watch    bool
+}
+
+var runFlg runFlag
+
 var rootCmd = &cobra.Command{
 	Use:   "yanct",
 	Short: "Yet Another NES CHR Tool",
@@ -52,7 +156,7 @@ func Execute() {
 
 func validatePal() error {
 	if flg.pal > 3 {
-		return fmt.Errorf("Invalid palette index (%s): %d", FlgPal, flg.pal)
+		return fmt.Errorf("Invalid palette index (%s): %d, it carries only the palette bits [0,3], use %s for the priority bit", FlgPal, flg.pal, FlgBehindBg)
 	}
 	return nil
 }
@@ -64,6 +168,14 @@ func validateBgColor() error {
 	return nil
 }
 
+func validateColorMap() error {
+	if len(flg.colorMap) > 0 && flg.bgColor > 0 {
+		return fmt.Errorf("Flags %s and %s cannot be used together", FlgBgColor, FlgColorMap)
+	}
+	_, err := newColorMap()
+	return err
+}
+
 func validateTileH() error {
 	if flg.tileH != 8 && flg.tileH != 16 {
 		return fmt.Errorf("Invalid tile height (%s): %d", FlgTileH, flg.tileH)
@@ -71,13 +183,30 @@ func validateTileH() error {
 	return nil
 }
 
+func tileDimension() chr.TileDimension {
+	if flg.tileH == 16 {
+		return chr.Tile8x16
+	}
+	return chr.Tile8x8
+}
+
 func validateMetasprFmt() error {
-	if flg.metasprFmt != MetaspriteOutputC && flg.metasprFmt != MetaspriteOutputASM && flg.metasprFmt != MetaspriteOutputBin {
-		return fmt.Errorf("Invalid metasprite output format (%s): %s", FlgMetasprFmt, flg.metasprFmt)
+	for _, format := range metasprFormats() {
+		if format != MetaspriteOutputC && format != MetaspriteOutputASM && format != MetaspriteOutputBin {
+			return fmt.Errorf("Invalid metasprite output format (%s): %s", FlgMetasprFmt, format)
+		}
 	}
 	return nil
 }
 
+//metasprFormats returns the metasprite output formats asked by the flags
+func metasprFormats() []string {
+	if len(flg.metasprFmts) > 0 {
+		return flg.metasprFmts
+	}
+	return []string{flg.metasprFmt}
+}
+
 func validateOutFileName() error {
 	if len(flg.fileOut) == 0 {
 		return fmt.Errorf("Invalid output file name (%s): %s", FlgOutFile, flg.fileOut)
@@ -85,7 +214,57 @@ func validateOutFileName() error {
 	return nil
 }
 
+func newColorMap() (chr.ColorMap, error) {
+	if len(flg.colorMap) == 0 {
+		return chr.NewColorMap(flg.bgColor), nil
+	}
+
+	colormap, err := chr.ParseColorMap(flg.colorMap)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid color map (%s): %s", FlgColorMap, err.Error())
+	}
+	return colormap, nil
+}
+
+//newImgColorMap returns the color map asked by the flags or, without it, the default map trimmed to the colors of an image palette,
+//so images with less than 4 colors are accepted
+func newImgColorMap(img image.PalettedImage) (chr.ColorMap, error) {
+	colormap, err := newColorMap()
+	if err != nil || len(flg.colorMap) > 0 {
+		return colormap, err
+	}
+
+	if palette, ok := img.ColorModel().(color.Palette); ok && len(palette) < len(colormap) {
+		colormap = colormap[:len(palette)]
+	}
+	return colormap, nil
+}
+
 func openImg(filename string) (image.PalettedImage, error) {
+	img, err := openPalettedImg(filename)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Dx() > 128 || img.Bounds().Dy() > 128 {
+		return nil, fmt.Errorf("Image '%s' must be a PNG file indexed with 4 colors and has the maximum dimension of 128x128 pixels", filename)
+	}
+
+	return img, nil
+}
+
+//openAnyImg opens a PNG image of any color model and dimension
+func openAnyImg(filename string) (image.Image, error) {
+	pngfile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer pngfile.Close()
+
+	return png.Decode(pngfile)
+}
+
+//openPalettedImg opens an indexed PNG image of any dimension
+func openPalettedImg(filename string) (image.PalettedImage, error) {
 	pngfile, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -98,9 +277,87 @@ func openImg(filename string) (image.PalettedImage, error) {
 	}
 
 	img, ok := decoded.(image.PalettedImage)
-	if !ok || img.Bounds().Dx() > 128 || img.Bounds().Dy() > 128 {
-		return nil, fmt.Errorf("Image '%s' must be a PNG file indexed with 4 colors and has the maximum dimension of 128x128 pixels", filename)
+	if !ok {
+		return nil, fmt.Errorf("Image '%s' must be an indexed PNG file", filename)
 	}
 
 	return img, nil
 }
+
+func validateCompress() error {
+	if flg.compress != chr.CompressNone && flg.compress != chr.CompressRLE && flg.compress != chr.CompressLZ4 {
+		return fmt.Errorf("Invalid compression (%s): %s", FlgCompress, flg.compress)
+	}
+	return nil
+}
+
+//validateFormat validates the output format of the data other than metasprites
+func validateFormat() error {
+	if flg.format != MetaspriteOutputC && flg.format != MetaspriteOutputASM && flg.format != MetaspriteOutputBin {
+		return fmt.Errorf("Invalid output format (%s): %s", FlgFormat, flg.format)
+	}
+	return nil
+}
+
+func validateDither() error {
+	if len(flg.dither) > 0 && flg.dither != chr.DitherNone && flg.dither != chr.DitherBayer && flg.dither != chr.DitherFloyd {
+		return fmt.Errorf("Invalid dithering (%s): %s", FlgDither, flg.dither)
+	}
+	if len(flg.dither) > 0 && len(flg.colorMap) > 0 {
+		return fmt.Errorf("Flags %s and %s cannot be used together", FlgDither, FlgColorMap)
+	}
+	if len(flg.subPalette) > 0 && len(flg.dither) == 0 {
+		return fmt.Errorf("Flag %s requires %s", FlgSubPalette, FlgDither)
+	}
+	_, err := subPalette()
+	return err
+}
+
+//subPalette parses the NES colors of the reduced image, or returns nil to choose them from the image
+func subPalette() (*[chr.SubPaletteSize]byte, error) {
+	if len(flg.subPalette) == 0 {
+		return nil, nil
+	}
+
+	var subpal [chr.SubPaletteSize]byte
+	fields := strings.Split(flg.subPalette, ",")
+	if len(fields) != chr.SubPaletteSize {
+		return nil, fmt.Errorf("Invalid sub-palette (%s): %s, expected %d colors", FlgSubPalette, flg.subPalette, chr.SubPaletteSize)
+	}
+	for i, field := range fields {
+		value, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(field), "0x"), 16, 8)
+		if err != nil || int(value) >= len(chr.NESPalette) {
+			return nil, fmt.Errorf("Invalid sub-palette color (%s): '%s', expected [00,3f]", FlgSubPalette, field)
+		}
+		subpal[i] = byte(value)
+	}
+	return &subpal, nil
+}
+
+//quantizeImg reduces a truecolor image to the 4 NES colors given by the flags or chosen from the image, with the dithering of the flags.
+//For sprites, the color 0 is transparent and only the colors [1,3] draw the opaque pixels.
+func quantizeImg(filename string, img image.Image, sprite bool) (*image.Paletted, []byte, error) {
+	subpal, err := subPalette()
+	if err != nil {
+		return nil, nil, err
+	}
+	if subpal == nil {
+		chosen := chr.NewSubPaletteFromImage(img, sprite)
+		subpal = &chosen
+	}
+
+	quantized, err := chr.Quantize(img, *subpal, flg.dither, sprite)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+	return quantized, subpal[:], nil
+}
+
+//writeSubPalette writes the NES colors of a reduced image into a .pal file, in the order of the CHR colors given by the color map, then returns the written file
+func writeSubPalette(subpal []byte, colormap chr.ColorMap, filename string) (string, error) {
+	var chrpal [chr.SubPaletteSize]byte
+	for i, idx := range subpal {
+		chrpal[colormap.Map(byte(i))] = idx
+	}
+	return chr.ChangeFileExtension(filename, "pal"), chr.WriteSubPalette(filename, chrpal)
+}
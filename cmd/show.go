@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+//TilesPerRow is the amount of tiles printed per row by the show command
+const TilesPerRow = 16
+
+//ANSI escape sequences
+const (
+	ansiReset = "\x1b[0m"
+	ansiDim   = "\x1b[2m"
+	ansiRed   = "\x1b[31m"
+)
+
+var showCmd = &cobra.Command{
+	Use:   "show CHR [METASPR]",
+	Short: "Print tiles or a metasprite to the terminal",
+	Long: `Print the tiles of a CHR file, or a metasprite drawn with them, to a terminal with 24-bit colors.
+Each character shows 2 pixels, one above the other, so the terminal font must have the upper half block character.
+The tiles are printed 16 per row, each one labeled with its index in hexadecimal and drawn with the chosen palette.
+With --highlight, the labels of the empty tiles are dimmed and the labels of duplicated tiles are red and point to the 1st tile:
+'12=03' is the tile 0x12 equal to the tile 0x03 and '12~03' is the tile 0x12 mirrored or flipped from the tile 0x03.
+The colors come from the NES palette, using a palette file or, without it, gray, red, green and blue palettes over black.`,
+	Example: `Print the tiles of 'hero.chr', with 8x16 tiles, using the 2nd palette of 'hero.pal' and highlighting the empty and duplicated tiles
+
+yanct show hero.chr --tile-height=16 --palette-file=hero.pal --pal=1 --highlight
+
+Print the metasprite 'hero.bin' drawn with the tiles of 'hero.chr'
+
+yanct show hero.chr hero.bin`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("Requires 1 CHR file and optionally 1 metasprite file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateTileH(); err != nil {
+			return err
+		}
+		if err := validatePal(); err != nil {
+			return err
+		}
+		return show(os.Stdout, args...)
+	},
+}
+
+func init() {
+	showCmd.Flags().Uint8VarP(&flg.tileH, FlgTileH, "t", 8, "Height of the tiles: 8 for 8x8, 16 for 8x16")
+	showCmd.Flags().Uint8VarP(&flg.pal, FlgPal, "p", 0, "Which palette to draw the tiles with [0,3] (default 0)")
+	showCmd.Flags().StringVar(&flg.paletteFile, FlgPaletteFile, "", "Palette file with the 4 sprite palettes (16 bytes)")
+	showCmd.Flags().BoolVar(&flg.highlight, FlgHighlight, false, "Highlight the empty and duplicated tiles")
+	rootCmd.AddCommand(showCmd)
+}
+
+func show(w io.Writer, filenames ...string) error {
+	tiledim := tileDimension()
+	tileset, err := openCHR(filenames[0], tiledim)
+	if err != nil {
+		return err
+	}
+
+	palettes, err := openPalettes()
+	if err != nil {
+		return err
+	}
+
+	if len(filenames) == 1 {
+		if tileset.Size()%(tiledim.Height()/8) != 0 {
+			return fmt.Errorf("CHR file %s has %d tiles, cannot show them as %s tiles", filenames[0], tileset.Size(), tiledim)
+		}
+		printTileset(w, tileset, tiledim, palettes)
+		return nil
+	}
+
+	metasprite, err := openMetasprite(filenames[1])
+	if err != nil {
+		return err
+	}
+
+	bounds := metasprite.Bounds(tiledim)
+	if bounds.Empty() {
+		return fmt.Errorf("Metasprite %s has no sprites", filenames[1])
+	}
+
+	img := chr.NewCanvas(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palettes)
+	if err := metasprite.Draw(img, image.Pt(-bounds.Min.X, -bounds.Min.Y), tileset, tiledim, palettes); err != nil {
+		return fmt.Errorf("Cannot draw %s: %s", filenames[1], err.Error())
+	}
+
+	fmt.Fprintf(w, "%s: origin at (%d,%d) of %dx%d pixels\n", filenames[1], -bounds.Min.X, -bounds.Min.Y, bounds.Dx(), bounds.Dy())
+	for y := 0; y < bounds.Dy(); y += 2 {
+		for x := 0; x < bounds.Dx(); x++ {
+			top := img.At(x, y)
+			bottom := top
+			if y+1 < bounds.Dy() {
+				bottom = img.At(x, y+1)
+			}
+			fmt.Fprint(w, halfBlock(top, bottom))
+		}
+		fmt.Fprintln(w, ansiReset)
+	}
+
+	return nil
+}
+
+//printTileset prints the tiles 16 per row, an 8x16 tile being the tile at its index above the next one
+func printTileset(w io.Writer, tileset *chr.Tileset, tiledim chr.TileDimension, palettes chr.Palettes) {
+	step := tiledim.Height() / 8
+	labels := tileLabels(tileset, step)
+
+	for first := 0; first < tileset.Size(); first += TilesPerRow * step {
+		var units []int
+		for i := first; i < first+TilesPerRow*step && i+step <= tileset.Size(); i += step {
+			units = append(units, i)
+		}
+
+		for _, i := range units {
+			fmt.Fprintf(w, "%s ", labels[i])
+		}
+		fmt.Fprintln(w)
+
+		for y := 0; y < tiledim.Height(); y += 2 {
+			for _, i := range units {
+				tile, py := tileset.At(i+y/8), y%8
+				for x := 0; x < 8; x++ {
+					top := chr.NESPalette[palettes.Color(flg.pal, tile.Pixel(x, py))]
+					bottom := chr.NESPalette[palettes.Color(flg.pal, tile.Pixel(x, py+1))]
+					fmt.Fprint(w, halfBlock(top, bottom))
+				}
+				fmt.Fprint(w, ansiReset+" ")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+//tileLabels returns the label, 8 characters wide, of each group of step tiles, by the index of its 1st tile
+func tileLabels(tileset *chr.Tileset, step int) map[int]string {
+	labels := make(map[int]string)
+	for i := 0; i+step <= tileset.Size(); i += step {
+		labels[i] = fmt.Sprintf("%-8s", fmt.Sprintf("%02x", i))
+		if !flg.highlight {
+			continue
+		}
+
+		if same, kind := findDuplicate(tileset, i, step); same >= 0 {
+			labels[i] = ansiRed + fmt.Sprintf("%-8s", fmt.Sprintf("%02x%s%02x", i, kind, same)) + ansiReset
+		} else if emptyTiles(tileset, i, step) {
+			labels[i] = ansiDim + labels[i] + ansiReset
+		}
+	}
+	return labels
+}
+
+//findDuplicate returns the 1st group of step tiles equal ("=") or, for 8x8 tiles, mirrored or flipped ("~") to the group at i, or -1 if none
+func findDuplicate(tileset *chr.Tileset, i, step int) (int, string) {
+	if emptyTiles(tileset, i, step) {
+		return -1, ""
+	}
+
+	for j := 0; j < i; j += step {
+		equal := true
+		for k := 0; k < step; k++ {
+			equal = equal && tileset.At(i+k).Equals(tileset.At(j+k))
+		}
+		if equal {
+			return j, "="
+		}
+	}
+
+	if step > 1 {
+		return -1, ""
+	}
+
+	tile := tileset.At(i)
+	for j := 0; j < i; j++ {
+		other := tileset.At(j)
+		if tile.Mirrored(other) || tile.Flipped(other) || tile.MirrorFlipped(other) {
+			return j, "~"
+		}
+	}
+
+	return -1, ""
+}
+
+func emptyTiles(tileset *chr.Tileset, i, step int) bool {
+	for k := 0; k < step; k++ {
+		if !tileset.At(i + k).Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+//halfBlock returns the upper half block character colored with the top pixel over the bottom pixel
+func halfBlock(top, bottom color.Color) string {
+	tr, tg, tb, _ := top.RGBA()
+	br, bg, bb, _ := bottom.RGBA()
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", tr>>8, tg>>8, tb>>8, br>>8, bg>>8, bb>>8)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var textCmd = &cobra.Command{
+	Use:   "text STRINGS",
+	Short: "Encode strings into tile indexes with a character table",
+	Long: `Encode the strings of a YAML file into tile indexes with a character table (.tbl), as written by font2chr.
+The YAML file maps the name of each string to its text, and the strings keep the order of the file.
+Each text is encoded taking at each position the longest characters mapped by the table, so the table may map whole words to a code.
+The line breaks are encoded with the newline code of the table (*HEX) and each string ends with the end code of the table (/HEX), if any.
+Other control codes, like a pause, are mapped by the table with a name that the texts use, e.g. FD=[pause].
+The encoding fails at the 1st character not mapped by the table.
+As C or assembly, each string is labeled <output>_<name> and followed by a table of pointers to the strings, labeled <output>.
+The binary output has the strings one after the other.`,
+	Example: `Encode the strings of 'dialog.yaml' with the table 'font.tbl' into the file dialog.inc
+
+yanct text dialog.yaml --table=font.tbl --format=asm
+
+Where 'dialog.yaml' contains:
+
+hello: "Hello![pause]"
+bye: |-
+  See you
+  soon!`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 strings file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(flg.table) == 0 {
+			return fmt.Errorf("Requires a character table (%s)", FlgTable)
+		}
+		if err := validateFormat(); err != nil {
+			return err
+		}
+
+		inputs := []string{args[0], flg.table}
+		if runFlg.watch {
+			return watch(cmd.Name(), func() []string { return inputs }, func() error { return text(args[0], inputs) })
+		}
+		return text(args[0], inputs)
+	},
+}
+
+func init() {
+	textCmd.Flags().StringVar(&flg.table, FlgTable, "", "Character table (.tbl) encoding the characters")
+	textCmd.Flags().StringVarP(&flg.format, FlgFormat, "f", "bin", "Output format: c, asm, bin")
+	textCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output file name, without extension (default is the strings file name)")
+	textCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs")
+	textCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and encode the strings again whenever they or the character table change")
+	rootCmd.AddCommand(textCmd)
+}
+
+func text(filename string, inputs []string) error {
+	outputs, err := cached("text", inputs, func() ([]string, error) { return encodeTexts(filename) })
+	if err != nil {
+		return err
+	}
+
+	return writeDepFile(depRule{outputs: outputs, inputs: inputs})
+}
+
+var stringName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func encodeTexts(filename string) ([]string, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var strs yaml.MapSlice
+	if err := yaml.Unmarshal(bytes, &strs); err != nil {
+		return nil, fmt.Errorf("Invalid strings file %s: %s", filename, err.Error())
+	}
+
+	var names, texts []string
+	seen := make(map[string]bool)
+	for _, item := range strs {
+		name := fmt.Sprint(item.Key)
+		if !stringName.MatchString(name) {
+			return nil, fmt.Errorf("Invalid strings file %s: name '%s' is not a valid label", filename, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Invalid strings file %s: name '%s' is repeated", filename, name)
+		}
+		seen[name] = true
+		text, ok := item.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid strings file %s: %s must be a string", filename, name)
+		}
+		names = append(names, name)
+		texts = append(texts, text)
+	}
+
+	table, err := openCharTable(flg.table)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := chr.NewTexts(names, texts, table)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot encode %s: %s", filename, err.Error())
+	}
+
+	outname := filename
+	if len(flg.fileOut) > 0 {
+		outname = flg.fileOut
+	}
+	return writeTexts(encoded, outname)
+}
+
+//writeTexts writes the encoded strings in the format asked by the flags, then returns the written files
+func writeTexts(texts *chr.Texts, filename string) ([]string, error) {
+	switch flg.format {
+	case MetaspriteOutputASM:
+		return []string{chr.ChangeFileExtension(filename, "inc")}, texts.WriteAsm(filename)
+	case MetaspriteOutputBin:
+		return []string{chr.ChangeFileExtension(filename, "bin")}, texts.WriteBin(filename)
+	case MetaspriteOutputC:
+		return []string{chr.ChangeFileExtension(filename, "c"), chr.ChangeFileExtension(filename, "h")}, texts.WriteC(filename)
+	}
+
+	return nil, fmt.Errorf("Invalid output format (%s): %s", FlgFormat, flg.format)
+}
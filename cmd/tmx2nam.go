@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"regexp"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+var tmx2namCmd = &cobra.Command{
+	Use:   "tmx2nam MAP",
+	Short: "Convert a Tiled map into a CHR + nametable files",
+	Long: `Convert a Tiled map (.tmx) into a CHR + nametable files.
+The images of the map tilesets, embedded or in .tsx files, are converted into a CHR with 8x8 tiles without duplicates, whose 1st tile is empty.
+Each tile layer is converted into a nametable of the same dimension, made of the tiles row by row followed by the attribute table.
+A map of 32x30 tiles gives the 1024 bytes of a NES nametable.
+The nametables can be compressed with the RLE format of vram_unrle of neslib or as raw LZ4 blocks.
+The empty tiles of a layer become the tile 0 and the tiles flipped or rotated are rejected, as the background tiles cannot be flipped.
+The palette of each tile comes from the integer property 'palette' [0,3] of the tileset tile (default 0) and must be the same for the 2x2 tiles of each 16x16 block.
+The tileset images must be indexed and their colors can be mapped to the CHR colors with --color-map.`,
+	Example: `Convert the map 'level1.tmx', with a layer 'ground' and another 'sky', into the files level1.chr, level1_ground.inc and level1_sky.inc
+
+yanct tmx2nam level1.tmx --format=asm
+
+Convert only the layer 'ground' of the map 'level1.tmx' into the files ground.chr and ground.bin
+
+yanct tmx2nam level1.tmx --layer=ground --output=ground`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 Tiled map file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateFormat(); err != nil {
+			return err
+		}
+		if err := validateCompress(); err != nil {
+			return err
+		}
+		if err := validateBgColor(); err != nil {
+			return err
+		}
+		if err := validateColorMap(); err != nil {
+			return err
+		}
+
+		if runFlg.watch {
+			return watch(cmd.Name(), func() []string { return tmxInputs(args[0]) }, func() error { return tmx2nam(args[0]) })
+		}
+		return tmx2nam(args[0])
+	},
+}
+
+func init() {
+	tmx2namCmd.Flags().Uint8VarP(&flg.bgColor, FlgBgColor, "b", 0, "Color index of the background [0,3] (default 0)")
+	tmx2namCmd.Flags().StringVarP(&flg.colorMap, FlgColorMap, "m", "", "Comma separated CHR color [0,3] of each image color index, e.g. 0,2,1,3 (replaces --bg-color)")
+	tmx2namCmd.Flags().StringVar(&flg.layer, FlgLayer, "", "Name of the only layer to convert (default is all tile layers)")
+	tmx2namCmd.Flags().StringVarP(&flg.format, FlgFormat, "f", "bin", "Nametable output format: c, asm, bin")
+	tmx2namCmd.Flags().StringVar(&flg.compress, FlgCompress, chr.CompressNone, "Compression of the nametables: none, rle (vram_unrle of neslib), lz4 (raw LZ4 block)")
+	tmx2namCmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output file name, without extension (default is the map file name)")
+	tmx2namCmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs, including the tilesets and their images")
+	tmx2namCmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and convert the map again whenever it or its tilesets change")
+	rootCmd.AddCommand(tmx2namCmd)
+}
+
+//tmxInputs returns the map file followed by the tileset files and images it uses, or only the map file if it cannot be read
+func tmxInputs(filename string) []string {
+	inputs := []string{filename}
+	tmap, err := chr.ReadTiledMap(filename)
+	if err != nil {
+		return inputs
+	}
+
+	for _, tileset := range tmap.Tilesets {
+		if len(tileset.Source) > 0 {
+			inputs = append(inputs, tileset.Source)
+		}
+		if tileset.Image != nil {
+			inputs = append(inputs, tileset.Image.Source)
+		}
+	}
+	return inputs
+}
+
+func tmx2nam(filename string) error {
+	inputs := tmxInputs(filename)
+	outputs, err := cached("tmx2nam", inputs, func() ([]string, error) { return convertTmx(filename) })
+	if err != nil {
+		return err
+	}
+
+	return writeDepFile(depRule{outputs: outputs, inputs: inputs})
+}
+
+func convertTmx(filename string) ([]string, error) {
+	tmap, err := chr.ReadTiledMap(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	colormap, err := newColorMap()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []image.PalettedImage
+	for _, tileset := range tmap.Tilesets {
+		if tileset.Image == nil {
+			return nil, fmt.Errorf("Cannot convert %s: tileset %s must be made of a single image", filename, tileset.Name)
+		}
+		img, err := openPalettedImg(tileset.Image.Source)
+		if err != nil {
+			return nil, err
+		}
+		imgmap, err := newImgColorMap(img)
+		if err != nil {
+			return nil, err
+		}
+		if err := imgmap.Validate(img); err != nil {
+			return nil, fmt.Errorf("Cannot convert %s: %s", tileset.Image.Source, err.Error())
+		}
+		images = append(images, img)
+	}
+
+	tileset, cells, err := chr.NewTilesetFromTiled(tmap, images, colormap)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	var layers []chr.TiledLayer
+	for _, layer := range tmap.Layers {
+		if len(flg.layer) == 0 || layer.Name == flg.layer {
+			layers = append(layers, layer)
+		}
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("Cannot convert %s: no tile layer named '%s'", filename, flg.layer)
+	}
+
+	outname := filename
+	if len(flg.fileOut) > 0 {
+		outname = flg.fileOut
+	}
+
+	if err := tileset.Write(outname); err != nil {
+		return nil, err
+	}
+	outputs := []string{chr.ChangeFileExtension(outname, "chr")}
+
+	for _, layer := range layers {
+		nametable, err := chr.NewNametableFromTiled(&layer, cells)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+		}
+
+		layername := outname
+		if len(layers) > 1 {
+			layername = addSuffix(outname, "_"+layerSuffix(layer.Name))
+		}
+		written, err := writeNametable(nametable, layername)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+		}
+		outputs = append(outputs, written...)
+	}
+
+	return outputs, nil
+}
+
+var nonLabelChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+//layerSuffix turns the name of a layer into a suffix usable in file names and labels
+func layerSuffix(name string) string {
+	return nonLabelChars.ReplaceAllString(name, "_")
+}
+
+//writeNametable writes a nametable in the format asked by the flags, then returns the written files
+func writeNametable(nametable *chr.Nametable, filename string) ([]string, error) {
+	nametable.SetCompression(flg.compress)
+	switch flg.format {
+	case MetaspriteOutputASM:
+		return []string{chr.ChangeFileExtension(filename, "inc")}, nametable.WriteAsm(filename)
+	case MetaspriteOutputBin:
+		return []string{chr.ChangeFileExtension(filename, "bin")}, nametable.WriteBin(filename)
+	case MetaspriteOutputC:
+		return []string{chr.ChangeFileExtension(filename, "c"), chr.ChangeFileExtension(filename, "h")}, nametable.WriteC(filename)
+	}
+
+	return nil, fmt.Errorf("Invalid output format (%s): %s", FlgFormat, flg.format)
+}
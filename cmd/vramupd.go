@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+
+	"github.com/parisoft/yanct/chr"
+	"github.com/spf13/cobra"
+)
+
+var vramupdCmd = &cobra.Command{
+	Use:   "vramupd",
+	Short: "Generate VRAM update lists",
+	Long: `Generate VRAM update lists, to upload tiles into CHR-RAM or to change the background while the game runs.
+Each list is a sequence of entries (address, length, bytes) ended by 0xff, in one of the formats:
+  neslib: the format of set_vram_update, where a single byte is MSB, LSB, BYTE and many bytes are MSB|0x40, LSB, LEN, BYTES or, when vertical, MSB|0x80, LSB, LEN, BYTES
+  raw: every entry is MSB, LSB, LEN, BYTES, with the bit 7 of MSB set when vertical (the address is incremented by 32)
+The updates are split into many lists, one per frame, when they exceed the byte budget of a frame (--budget), each list being labeled <output>_<frame>.
+In the neslib format a list has at most 256 bytes, including the ending 0xff, as flush_vram_update cannot read more, so the budget never exceeds it.
+The binary output has the lists one after the other.`,
+}
+
+var vramupdTilesCmd = &cobra.Command{
+	Use:   "tiles CHR",
+	Short: "Generate the VRAM update lists uploading tiles into CHR-RAM",
+	Long: `Generate the VRAM update lists uploading a range of tiles of a CHR file into the pattern tables, at an address of the CHR-RAM.
+Each tile takes 16 bytes, the 1st pattern table being at 0x0000 and the 2nd at 0x1000.`,
+	Example: `Upload the tiles 16 to 47 of 'font.chr' into the 2nd pattern table, from its tile 16, with at most 160 bytes per frame, into 'font_vram.h' and 'font_vram.c'
+
+yanct vramupd tiles font.chr --first=16 --count=32 --addr=0x1100 --budget=160 --format=c`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 CHR file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVramupd(cmd, args[0], func() ([]chr.VramUpdate, error) { return tileUpdates(args[0]) })
+	},
+}
+
+var vramupdNametableCmd = &cobra.Command{
+	Use:   "nametable NAMETABLE",
+	Short: "Generate the VRAM update lists writing a region of a nametable",
+	Long: `Generate the VRAM update lists writing a region of a binary nametable of 32x30 tiles, as written by img2bg or tmx2nam, into a nametable of the PPU.
+The tiles of the region are written row by row or, with --vertical, column by column, followed by the attributes covering the region.
+The region is written at the same position of the nametable at --addr: 0x2000, 0x2400, 0x2800 or 0x2c00.`,
+	Example: `Write the 2 columns from the column 30 of 'level1.bin' into the 2nd nametable, into 'level1_vram.inc'
+
+yanct vramupd nametable level1.bin --x=30 --width=2 --vertical --addr=0x2400 --format=asm`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Requires 1 nametable file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVramupd(cmd, args[0], func() ([]chr.VramUpdate, error) { return nametableUpdates(args[0]) })
+	},
+}
+
+func init() {
+	vramupdTilesCmd.Flags().Uint16Var(&flg.first, FlgFirst, 0, "Index of the 1st tile to upload")
+	vramupdTilesCmd.Flags().Uint16Var(&flg.count, FlgCount, 0, "How many tiles to upload (default is up to the last tile)")
+	vramupdTilesCmd.Flags().Uint16Var(&flg.tileAddr, FlgAddr, 0x0000, "Address of the CHR-RAM where the 1st tile goes")
+	vramupdNametableCmd.Flags().Uint8Var(&flg.x, FlgX, 0, "Column of the 1st tile of the region [0,31]")
+	vramupdNametableCmd.Flags().Uint8Var(&flg.y, FlgY, 0, "Row of the 1st tile of the region [0,29]")
+	vramupdNametableCmd.Flags().Uint8Var(&flg.width, FlgWidth, 0, "Width of the region in tiles (default is up to the last column)")
+	vramupdNametableCmd.Flags().Uint8Var(&flg.height, FlgHeight, 0, "Height of the region in tiles (default is up to the last row)")
+	vramupdNametableCmd.Flags().BoolVar(&flg.vertical, FlgVertical, false, "Write the tiles column by column")
+	vramupdNametableCmd.Flags().Uint16Var(&flg.ntAddr, FlgAddr, chr.NametableAddr, "Address of the nametable to write")
+
+	for _, cmd := range []*cobra.Command{vramupdTilesCmd, vramupdNametableCmd} {
+		cmd.Flags().Uint16Var(&flg.budget, FlgBudget, 0, "Max bytes of the list of each frame, including the ending 0xff (default is a single list, or 256 bytes in the neslib format)")
+		cmd.Flags().StringVar(&flg.vramFmt, FlgVramFmt, chr.VramFormatNeslib, "Format of the lists: neslib, raw")
+		cmd.Flags().StringVarP(&flg.format, FlgFormat, "f", "bin", "Output format: c, asm, bin")
+		cmd.Flags().StringVarP(&flg.fileOut, FlgOutFile, "o", "", "Output file name, without extension (default is the input file name with the suffix _vram)")
+		cmd.Flags().StringVar(&runFlg.depFile, FlgDepFile, "", "Write a Make dependency file listing the outputs and inputs")
+		cmd.Flags().BoolVarP(&runFlg.watch, FlgWatch, "w", false, "Keep running and generate the lists again whenever the input changes")
+		vramupdCmd.AddCommand(cmd)
+	}
+
+	rootCmd.AddCommand(vramupdCmd)
+}
+
+func runVramupd(cmd *cobra.Command, filename string, updates func() ([]chr.VramUpdate, error)) error {
+	if err := validateFormat(); err != nil {
+		return err
+	}
+	if err := validateVramFmt(); err != nil {
+		return err
+	}
+	if flg.budget != 0 && flg.budget < 5 {
+		return fmt.Errorf("Invalid budget (%s): %d, a list has at least 5 bytes", FlgBudget, flg.budget)
+	}
+
+	run := func() error { return vramupd(cmd.Name(), filename, updates) }
+	if runFlg.watch {
+		return watch(cmd.Name(), func() []string { return []string{filename} }, run)
+	}
+	return run()
+}
+
+func vramupd(name, filename string, updates func() ([]chr.VramUpdate, error)) error {
+	inputs := []string{filename}
+	outputs, err := cached("vramupd "+name, inputs, func() ([]string, error) { return convertVramupd(filename, updates) })
+	if err != nil {
+		return err
+	}
+
+	return writeDepFile(depRule{outputs: outputs, inputs: inputs})
+}
+
+func convertVramupd(filename string, updates func() ([]chr.VramUpdate, error)) ([]string, error) {
+	upds, err := updates()
+	if err != nil {
+		return nil, err
+	}
+	lists, err := chr.NewVramUpdateLists(upds, flg.vramFmt, int(flg.budget))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	outname := addSuffix(filename, "_vram")
+	if len(flg.fileOut) > 0 {
+		outname = flg.fileOut
+	}
+	return writeVramUpdateLists(lists, outname)
+}
+
+func tileUpdates(filename string) ([]chr.VramUpdate, error) {
+	tileset, err := openCHR(filename, chr.Tile8x8)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int(flg.count)
+	if count == 0 {
+		count = tileset.Size() - int(flg.first)
+	}
+	update, err := chr.NewTileUpdate(tileset, int(flg.first), count, flg.tileAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	return []chr.VramUpdate{update}, nil
+}
+
+func nametableUpdates(filename string) ([]chr.VramUpdate, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	nametable, err := chr.NewNametableFromBytes(bytes, 32, 30)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	region := image.Rect(int(flg.x), int(flg.y), nametable.Width(), nametable.Height())
+	if flg.width > 0 {
+		region.Max.X = region.Min.X + int(flg.width)
+	}
+	if flg.height > 0 {
+		region.Max.Y = region.Min.Y + int(flg.height)
+	}
+	updates, err := chr.NewNametableUpdates(nametable, region, flg.ntAddr, flg.vertical)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot convert %s: %s", filename, err.Error())
+	}
+
+	return updates, nil
+}
+
+//writeVramUpdateLists writes the lists in the format asked by the flags, then returns the written files
+func writeVramUpdateLists(lists *chr.VramUpdateLists, filename string) ([]string, error) {
+	switch flg.format {
+	case MetaspriteOutputASM:
+		return []string{chr.ChangeFileExtension(filename, "inc")}, lists.WriteAsm(filename)
+	case MetaspriteOutputBin:
+		return []string{chr.ChangeFileExtension(filename, "bin")}, lists.WriteBin(filename)
+	case MetaspriteOutputC:
+		return []string{chr.ChangeFileExtension(filename, "c"), chr.ChangeFileExtension(filename, "h")}, lists.WriteC(filename)
+	}
+
+	return nil, fmt.Errorf("Invalid output format (%s): %s", FlgFormat, flg.format)
+}
+
+func validateVramFmt() error {
+	if flg.vramFmt != chr.VramFormatNeslib && flg.vramFmt != chr.VramFormatRaw {
+		return fmt.Errorf("Invalid VRAM update format (%s): %s", FlgVramFmt, flg.vramFmt)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	watchPollInterval = 200 * time.Millisecond
+	watchDebounce     = 300 * time.Millisecond
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+//watch runs a job, then runs it again every time one of its inputs changes.
+//It never returns, failures are reported and the watch goes on.
+func watch(job string, inputs func() []string, run func() error) error {
+	for {
+		files := inputs()
+		start := time.Now()
+		if err := run(); err != nil {
+			fmt.Printf("%s FAIL %s: %s\n", start.Format("15:04:05"), job, err.Error())
+		} else {
+			fmt.Printf("%s ok   %s %s (%s)\n", start.Format("15:04:05"), job, strings.Join(files, " "), time.Since(start).Round(time.Millisecond))
+		}
+
+		// snapshot after the run to ignore the inputs rewritten by the job itself
+		waitChange(files, snapshot(files))
+	}
+}
+
+//waitChange blocks until the files differ from the snapshot and stop changing for a while, since a save may take many writes
+func waitChange(files []string, last map[string]fileState) {
+	for {
+		time.Sleep(watchPollInterval)
+		if current := snapshot(files); !sameSnapshot(last, current) {
+			last = current
+			break
+		}
+	}
+
+	for {
+		time.Sleep(watchDebounce)
+		current := snapshot(files)
+		if sameSnapshot(last, current) {
+			return
+		}
+		last = current
+	}
+}
+
+func snapshot(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for _, file := range files {
+		if stat, err := os.Stat(file); err == nil {
+			states[file] = fileState{modTime: stat.ModTime(), size: stat.Size()}
+		}
+	}
+	return states
+}
+
+func sameSnapshot(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, state := range a {
+		if other, ok := b[file]; !ok || other != state {
+			return false
+		}
+	}
+	return true
+}